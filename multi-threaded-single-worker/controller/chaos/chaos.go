@@ -0,0 +1,290 @@
+// Package chaos wraps connections to inject the faults a flaky network or a
+// misbehaving peer would cause: latency, writes split into pieces, dropped and
+// garbled messages, and connections that go away mid-conversation.
+//
+// Faults act on frames, the newline terminated JSON messages the protocol
+// sends, so that a dropped message disappears whole.
+package chaos
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plan is the faults injected into one direction of a connection
+type Plan struct {
+	// Frames let through untouched before any fault applies
+	After int
+	// Delay before each frame, plus up to Jitter more
+	Latency time.Duration
+	Jitter  time.Duration
+	// Split frames into reads or writes of at most Chunk bytes
+	Chunk int
+	// Chance of dropping or garbling each frame, from 0 to 1
+	Drop   float64
+	Garble float64
+	// Close the connection instead of passing the frame after this many,
+	// 0 never
+	CloseAfter int
+}
+
+// Faults is what to inject into what a connection reads and what it writes
+type Faults struct {
+	Read  Plan
+	Write Plan
+	// Seed for the fault dice, 0 picks one from the clock
+	Seed int64
+}
+
+// Zero reports whether f injects nothing
+func (f Faults) Zero() bool {
+	return f.Read == Plan{} && f.Write == Plan{}
+}
+
+// Parse reads faults from a comma separated list of key=value pairs, e.g.
+// "latency=50ms,read.drop=0.1,write.close-after=20,seed=7". A key applies to
+// both directions unless prefixed with read. or write.; the keys are after,
+// latency, jitter, chunk, drop, garble and close-after, plus seed.
+func Parse(spec string) (Faults, error) {
+	var f Faults
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return f, fmt.Errorf("fault %q: want key=value", field)
+		}
+		if key == "seed" {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return f, fmt.Errorf("fault seed: %w", err)
+			}
+			f.Seed = seed
+			continue
+		}
+
+		plans := []*Plan{&f.Read, &f.Write}
+		if dir, name, ok := strings.Cut(key, "."); ok {
+			switch dir {
+			case "read":
+				plans = []*Plan{&f.Read}
+			case "write":
+				plans = []*Plan{&f.Write}
+			default:
+				return f, fmt.Errorf("fault %q: direction must be read or write", field)
+			}
+			key = name
+		}
+		for _, p := range plans {
+			if err := p.set(key, value); err != nil {
+				return f, fmt.Errorf("fault %q: %w", field, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (p *Plan) set(key string, value string) error {
+	var err error
+	switch key {
+	case "after":
+		p.After, err = count(value)
+	case "latency":
+		p.Latency, err = time.ParseDuration(value)
+	case "jitter":
+		p.Jitter, err = time.ParseDuration(value)
+	case "chunk":
+		p.Chunk, err = count(value)
+	case "drop":
+		p.Drop, err = chance(value)
+	case "garble":
+		p.Garble, err = chance(value)
+	case "close-after":
+		p.CloseAfter, err = count(value)
+	default:
+		return fmt.Errorf("unknown fault %q", key)
+	}
+	return err
+}
+
+func count(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%d is negative", n)
+	}
+	return n, err
+}
+
+func chance(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err == nil && (p < 0 || p > 1) {
+		err = fmt.Errorf("%g is not between 0 and 1", p)
+	}
+	return p, err
+}
+
+// Conn is a net.Conn with faults injected into its traffic
+type Conn struct {
+	net.Conn
+	faults Faults
+
+	diceMu sync.Mutex
+	dice   *rand.Rand
+
+	readMu     sync.Mutex
+	readFrames int
+	partial    []byte // read from the connection, no newline yet
+	pending    []byte // frames that made it through, for Read to return
+	readErr    error
+
+	writeMu     sync.Mutex
+	writeFrames int
+}
+
+// Wrap injects faults into conn's traffic
+func Wrap(conn net.Conn, faults Faults) *Conn {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Conn{Conn: conn, faults: faults, dice: rand.New(rand.NewSource(seed))}
+}
+
+func (c *Conn) roll() float64 {
+	c.diceMu.Lock()
+	defer c.diceMu.Unlock()
+	return c.dice.Float64()
+}
+
+func (c *Conn) intn(n int) int {
+	c.diceMu.Lock()
+	defer c.diceMu.Unlock()
+	return c.dice.Intn(n)
+}
+
+// apply runs frame number n through plan and returns what is left of it, nil
+// if it was dropped, and false if the connection should close instead
+func (c *Conn) apply(plan Plan, n int, frame []byte) ([]byte, bool) {
+	if n <= plan.After {
+		return frame, true
+	}
+	if plan.CloseAfter > 0 && n > plan.CloseAfter {
+		return nil, false
+	}
+	if plan.Latency > 0 || plan.Jitter > 0 {
+		delay := plan.Latency
+		if plan.Jitter > 0 {
+			delay += time.Duration(c.roll() * float64(plan.Jitter))
+		}
+		time.Sleep(delay)
+	}
+	if plan.Drop > 0 && c.roll() < plan.Drop {
+		return nil, true
+	}
+	if plan.Garble > 0 && c.roll() < plan.Garble {
+		frame = c.garble(frame)
+	}
+	return frame, true
+}
+
+// garble overwrites bytes of frame with NULs, which JSON allows neither in
+// nor between values, keeping the newline so the next frame still parses
+func (c *Conn) garble(frame []byte) []byte {
+	garbled := bytes.Clone(frame)
+	body := len(garbled)
+	if body > 0 && garbled[body-1] == '\n' {
+		body--
+	}
+	if body == 0 {
+		return garbled
+	}
+	for i := 0; i < 1+body/16; i++ {
+		garbled[c.intn(body)] = 0
+	}
+	return garbled
+}
+
+// Write treats every call as one frame, which is how json.Encoder writes
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeFrames++
+	frame, ok := c.apply(c.faults.Write, c.writeFrames, p)
+	if !ok {
+		c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+
+	chunk := c.faults.Write.Chunk
+	if chunk <= 0 || c.writeFrames <= c.faults.Write.After {
+		chunk = len(frame)
+	}
+	for len(frame) > 0 {
+		n := min(chunk, len(frame))
+		if _, err := c.Conn.Write(frame[:n]); err != nil {
+			return 0, err
+		}
+		frame = frame[n:]
+	}
+	return len(p), nil
+}
+
+// Read returns the frames that survive the read faults, at most Chunk bytes
+// at a time
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		buf := make([]byte, 4096)
+		n, err := c.Conn.Read(buf)
+		c.partial = append(c.partial, buf[:n]...)
+
+		for {
+			i := bytes.IndexByte(c.partial, '\n')
+			if i < 0 {
+				break
+			}
+			frame := c.partial[:i+1]
+			c.partial = c.partial[i+1:]
+
+			c.readFrames++
+			kept, ok := c.apply(c.faults.Read, c.readFrames, frame)
+			if !ok {
+				c.Conn.Close()
+				c.partial = nil
+				c.readErr = net.ErrClosed
+				break
+			}
+			c.pending = append(c.pending, kept...)
+		}
+
+		if err != nil {
+			// Hand over what is left, then the error
+			c.pending = append(c.pending, c.partial...)
+			c.partial = nil
+			c.readErr = err
+		}
+	}
+
+	limit := len(p)
+	if chunk := c.faults.Read.Chunk; chunk > 0 && c.readFrames > c.faults.Read.After {
+		limit = min(limit, chunk)
+	}
+	n := copy(p[:limit], c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
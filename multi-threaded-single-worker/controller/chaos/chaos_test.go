@@ -0,0 +1,126 @@
+package chaos
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("latency=50ms, read.drop=0.25,write.close-after=3,chunk=7,seed=9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Faults{
+		Read:  Plan{Latency: 50 * time.Millisecond, Drop: 0.25, Chunk: 7},
+		Write: Plan{Latency: 50 * time.Millisecond, CloseAfter: 3, Chunk: 7},
+		Seed:  9,
+	}
+	if f != want {
+		t.Fatalf("got %+v, want %+v", f, want)
+	}
+
+	if f, err := Parse(""); err != nil || !f.Zero() {
+		t.Fatalf("empty spec gave %+v, %v", f, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"latency",
+		"latency=fast",
+		"drop=2",
+		"chunk=-1",
+		"sideways.drop=0.1",
+		"explode=1",
+		"seed=x",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+// pipe wraps the writing end of a net.Pipe in faults and sends frames
+// through it, returning the lines that arrive
+func pipe(t *testing.T, faults Faults, frames ...string) []string {
+	t.Helper()
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := Wrap(a, faults)
+
+	go func() {
+		defer conn.Close()
+		for _, frame := range frames {
+			if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	var got []string
+	scanner := bufio.NewScanner(b)
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	return got
+}
+
+func TestWrite(t *testing.T) {
+	frames := []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`}
+
+	if got := pipe(t, Faults{Write: Plan{Chunk: 2}}, frames...); strings.Join(got, " ") != strings.Join(frames, " ") {
+		t.Errorf("chunked: got %q", got)
+	}
+	if got := pipe(t, Faults{Write: Plan{After: 1, Drop: 1}}, frames...); len(got) != 1 || got[0] != frames[0] {
+		t.Errorf("dropped after 1: got %q", got)
+	}
+	if got := pipe(t, Faults{Write: Plan{CloseAfter: 2}}, frames...); len(got) != 2 {
+		t.Errorf("closed after 2: got %q", got)
+	}
+
+	got := pipe(t, Faults{Write: Plan{Garble: 1}, Seed: 1}, frames...)
+	if len(got) != len(frames) {
+		t.Fatalf("garbled: got %d frames, want %d", len(got), len(frames))
+	}
+	for i := range got {
+		if !strings.Contains(got[i], "\x00") {
+			t.Errorf("frame %q not garbled", got[i])
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	a, b := net.Pipe()
+	conn := Wrap(b, Faults{Read: Plan{CloseAfter: 2, Chunk: 3}})
+	go func() {
+		defer a.Close()
+		io.WriteString(a, "one\ntwo\n")
+		io.WriteString(a, "three\n")
+	}()
+
+	// Two frames come through three bytes at a time, the third closes the
+	// connection
+	buf := make([]byte, 64)
+	var got string
+	for {
+		n, err := conn.Read(buf)
+		if n > 3 {
+			t.Fatalf("read %d bytes, want at most 3", n)
+		}
+		got += string(buf[:n])
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("read error %v, want the connection closed", err)
+			}
+			break
+		}
+	}
+	if got != "one\ntwo\n" {
+		t.Fatalf("read %q, want %q", got, "one\ntwo\n")
+	}
+}
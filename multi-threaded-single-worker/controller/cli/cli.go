@@ -0,0 +1,53 @@
+// Package cli holds what the cracker subcommands share about parsing their
+// flags: help text, and defaults taken from the environment
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix starts the environment variable every flag can be set from
+const EnvPrefix = "CRACKER_"
+
+// EnvName is the environment variable for the flag called name, e.g.
+// CRACKER_LOG_FORMAT for -log-format
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// NewFlagSet makes the flag set for a subcommand, with help text made from
+// its synopsis and description
+func NewFlagSet(name, synopsis, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: cracker %s %s\n\n%s\n\nFlags (each can also be set from the environment variable shown):\n", name, synopsis, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parse sets every flag in fs from its environment variable and then from
+// args, so the command line wins over the environment
+func Parse(fs *flag.FlagSet, args []string) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		f.Usage += fmt.Sprintf(" [$%s]", name)
+
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("$%s: %w", name, setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
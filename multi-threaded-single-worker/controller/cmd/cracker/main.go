@@ -0,0 +1,79 @@
+// Command cracker runs every part of the distributed password cracker: the
+// controller, its workers, and the tools around them
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"cracker/controller"
+	"cracker/swarm"
+	"cracker/worker"
+)
+
+type command struct {
+	run     func(args []string)
+	summary string
+}
+
+var commands = map[string]command{
+	"controller": {controller.Main, "serve a cracking job to workers"},
+	"worker":     {worker.Main, "crack chunks handed out by a controller"},
+	"swarm":      {swarm.Main, "load test a controller with simulated workers"},
+	"bench":      {bench, "measure this machine's hash rate"},
+	"verify":     {verify, "check a password against a hash"},
+	"inspect":    {inspect, "summarize a session file, run report or recording"},
+	"show":       {show, "print the passwords cracked in a session"},
+	"gen":        {gen, "generate a shadow line for a password"},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cracker <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "cracker <command> -help" for the flags of a command.`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 0 {
+			if cmd, ok := commands[args[0]]; ok {
+				cmd.run([]string{"-help"})
+			}
+		}
+		usage()
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cracker: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(args)
+}
+
+// fail reports err the way every tool subcommand does
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "cracker:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"cracker/cli"
+	"cracker/controller"
+	"cracker/crypt"
+	"cracker/protocol"
+	"cracker/worker"
+)
+
+// hashFrom reads the hash to work on from -hash, or from -shadow and -user
+func hashFrom(hash, shadowFile, username string) (string, error) {
+	if hash != "" {
+		return hash, nil
+	}
+	if shadowFile == "" || username == "" {
+		return "", fmt.Errorf("need --hash, or --shadow and --user")
+	}
+	job, err := protocol.FindUserInShadow(shadowFile, username)
+	if err != nil {
+		return "", err
+	}
+	return job.FullHash, nil
+}
+
+func bench(args []string) {
+	fs := cli.NewFlagSet("bench", "[--algorithm NAME|all | --passwords DIR | --hash HASH | --shadow FILE --user USERNAME] [--scaling] [flags]",
+		"Hashes candidates for a while the way a worker does and prints the rate reached, to size chunks and compare algorithms and machines. With --scaling it also runs on fewer threads and reports how well the rate scales.")
+	algorithm := fs.String("algorithm", "md5", "algorithm to benchmark with a fresh salt, or all")
+	passwordsDir := fs.String("passwords", "", "benchmark each algorithm used by the shadow files in this directory instead")
+	hash := fs.String("hash", "", "benchmark with the setting of this hash instead")
+	shadowFile := fs.String("shadow", "", "benchmark with the setting of a user's hash in this shadow file instead")
+	username := fs.String("user", "", "user to take from --shadow")
+	threads := fs.Int("threads", runtime.NumCPU(), "number of hashing threads")
+	scaling := fs.Bool("scaling", false, "also run on 1, 2, 4... threads up to --threads and report the scaling efficiency")
+	duration := fs.Duration("duration", 5*time.Second, "how long to run each benchmark")
+	if err := cli.Parse(fs, args); err != nil {
+		fail(err)
+	}
+	if *threads <= 0 || *duration <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var targets []benchTarget
+	switch {
+	case *hash != "" || *shadowFile != "":
+		setting, err := hashFrom(*hash, *shadowFile, *username)
+		if err != nil {
+			fail(err)
+		}
+		targets = append(targets, benchTarget{protocol.Algorithm(setting), setting})
+	case *passwordsDir != "":
+		var err error
+		if targets, err = settingsIn(*passwordsDir); err != nil {
+			fail(err)
+		}
+	default:
+		names := []string{*algorithm}
+		if *algorithm == "all" {
+			names = names[:0]
+			for name := range crypt.Prefixes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			setting, err := crypt.Salt(name)
+			if err != nil {
+				fail(err)
+			}
+			targets = append(targets, benchTarget{name, setting})
+		}
+	}
+
+	counts := []int{*threads}
+	if *scaling {
+		counts = counts[:0]
+		for n := 1; n < *threads; n *= 2 {
+			counts = append(counts, n)
+		}
+		counts = append(counts, *threads)
+	}
+
+	fmt.Printf("%-10s %8s %12s %14s", "ALGORITHM", "THREADS", "HASHES", "RATE/s")
+	if *scaling {
+		fmt.Printf(" %8s %10s", "SPEEDUP", "EFFICIENCY")
+	}
+	fmt.Println()
+	for _, t := range targets {
+		// Efficiency is the speedup over one thread per thread added
+		var single float64
+		for _, n := range counts {
+			res, err := worker.Bench(context.Background(), t.setting, n, *duration)
+			if err != nil {
+				fail(fmt.Errorf("%s: %w", t.name, err))
+			}
+			fmt.Printf("%-10s %8d %12d %14.2f", t.name, res.Threads, res.Hashes, res.Rate())
+			if n == 1 {
+				single = res.Rate()
+			}
+			if *scaling && single > 0 {
+				speedup := res.Rate() / single
+				fmt.Printf(" %7.2fx %9.1f%%", speedup, 100*speedup/float64(n))
+			}
+			fmt.Println()
+		}
+	}
+}
+
+type benchTarget struct{ name, setting string }
+
+// settingsIn takes one setting per algorithm from the shadow files in dir,
+// the first hash of each it comes across, sorted by algorithm
+func settingsIn(dir string) ([]benchTarget, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var targets []benchTarget
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			username, fullHash, ok := strings.Cut(strings.TrimSpace(line), ":")
+			if !ok {
+				continue
+			}
+			fullHash, _, _ = strings.Cut(fullHash, ":")
+			job, err := protocol.ParseHash(username, fullHash)
+			if err != nil {
+				continue
+			}
+			name := protocol.Algorithm(job.Setting)
+			if !seen[name] {
+				seen[name] = true
+				targets = append(targets, benchTarget{name, job.FullHash})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no crackable hashes in %s", dir)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets, nil
+}
+
+func verify(args []string) {
+	fs := cli.NewFlagSet("verify", "--password PASSWORD (--hash HASH | --shadow FILE --user USERNAME)",
+		"Checks a password against a hash, exiting 0 when it matches and 1 when it does not.")
+	password := fs.String("password", "", "password to check")
+	hash := fs.String("hash", "", "hash to check against")
+	shadowFile := fs.String("shadow", "", "shadow file to take the hash from")
+	username := fs.String("user", "", "user whose hash to take from --shadow")
+	if err := cli.Parse(fs, args); err != nil {
+		fail(err)
+	}
+
+	fullHash, err := hashFrom(*hash, *shadowFile, *username)
+	if err != nil {
+		fs.Usage()
+		fail(err)
+	}
+	ok, err := crypt.Verify(*password, fullHash)
+	if err != nil {
+		fail(err)
+	}
+	if !ok {
+		fmt.Println("no match")
+		os.Exit(1)
+	}
+	fmt.Println("match")
+}
+
+func inspect(args []string) {
+	fs := cli.NewFlagSet("inspect", "FILE",
+		"Summarizes a controller session file, a run report written with --report, or a protocol recording written with --record.")
+	if err := cli.Parse(fs, args); err != nil {
+		fail(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := controller.Inspect(os.Stdout, fs.Arg(0)); err != nil {
+		fail(err)
+	}
+}
+
+func show(args []string) {
+	fs := cli.NewFlagSet("show", "[--session FILE]",
+		"Prints the passwords cracked in a controller session as user:password lines.")
+	sessionFile := fs.String("session", "controller.session", "session file to read")
+	if err := cli.Parse(fs, args); err != nil {
+		fail(err)
+	}
+	if err := controller.Show(os.Stdout, *sessionFile); err != nil {
+		fail(err)
+	}
+}
+
+func gen(args []string) {
+	fs := cli.NewFlagSet("gen", "--password PASSWORD [--user USERNAME] [--algorithm NAME]",
+		"Hashes a password with a fresh salt and prints it as a shadow file line, to make test inputs.")
+	password := fs.String("password", "", "password to hash")
+	username := fs.String("user", "user", "user name for the line")
+	algorithm := fs.String("algorithm", "md5", "algorithm to hash with: md5, sha256, sha512, bcrypt or yescrypt")
+	if err := cli.Parse(fs, args); err != nil {
+		fail(err)
+	}
+	if *password == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	setting, err := crypt.Salt(*algorithm)
+	if err != nil {
+		fail(err)
+	}
+	hash, err := crypt.Hash(*password, setting)
+	if err != nil {
+		fail(err)
+	}
+	days := time.Now().Unix() / (24 * 60 * 60)
+	fmt.Printf("%s:%s:%d:0:99999:7:::\n", *username, hash, days)
+}
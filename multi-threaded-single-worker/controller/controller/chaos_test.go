@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"testing"
+
+	"cracker/chaos"
+)
+
+func faults(t *testing.T, spec string) chaos.Faults {
+	t.Helper()
+	f, err := chaos.Parse(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// ACE is in the first chunk, which the first worker is handed
+func aceJob(t *testing.T) *harness {
+	return newHarness(t, bruteForceJob(t, "shadow_ACE_md5", sampleCharset, 3))
+}
+
+func TestChaosWorkerDropsOff(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		worker     string
+		controller string
+	}{
+		{name: "hangs up mid-job", worker: "write.close-after=1"},
+		{name: "garbles what it sends", worker: "write.after=1,write.garble=1"},
+		{name: "is sent a garbled job", controller: "write.garble=1"},
+		{name: "connection closes under it", controller: "read.close-after=1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h := aceJob(t)
+			h.addFaultyWorker("w1", 1, faults(t, tc.worker), faults(t, tc.controller))
+			h.waitFor("w1 to drop its chunk", func(s *session) bool {
+				_, assigned := s.assignments["w1"]
+				_, connected := s.conns["w1"]
+				return assigned && !connected
+			})
+
+			// The chunk with the password is up for grabs
+			h.addWorker("w2", 1)
+			if result := h.run(); result.Password != "ACE" {
+				t.Fatalf("got %+v, want ACE", result)
+			}
+		})
+	}
+}
+
+func TestChaosSilentWorkerHandedOn(t *testing.T) {
+	h := aceJob(t)
+
+	// w1 takes the chunk with the password, then nothing it says arrives
+	h.addFaultyWorker("w1", 1, faults(t, "write.after=1,write.drop=1"), chaos.Faults{})
+	h.waitFor("w1 to be assigned", func(s *session) bool {
+		_, ok := s.assignments["w1"]
+		return ok
+	})
+
+	// w2 runs through the rest of the keyspace and goes idle until w1 times
+	// out and its chunk is handed over
+	h.addWorker("w2", 1)
+	h.waitFor("w2 to go idle", func(s *session) bool {
+		_, busy := s.assignments["w2"]
+		return s.next == s.job.End && !busy
+	})
+
+	if result := h.run(); result.Password != "ACE" {
+		t.Fatalf("got %+v, want ACE", result)
+	}
+	if v := h.sess.view(); len(v.Workers) != 2 {
+		t.Fatalf("%d workers took part, want 2", len(v.Workers))
+	}
+}
+
+func TestChaosSlowNetwork(t *testing.T) {
+	// Every message arrives late and in pieces, on both ends
+	h := newHarness(t, bruteForceJob(t, "shadow_EAR_md5", "RDCBAE", 3))
+	slow := faults(t, "latency=2ms,jitter=5ms,chunk=7,seed=1")
+	h.addFaultyWorker("w1", 1, slow, slow)
+	h.addFaultyWorker("w2", 1, slow, slow)
+
+	if result := h.run(); result.Password != "EAR" {
+		t.Fatalf("got %+v, want EAR", result)
+	}
+}
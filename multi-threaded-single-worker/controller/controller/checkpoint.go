@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cracker/protocol"
+)
+
+const checkpointVersion = 2
+
+// checkpoint is the on-disk form of a session. It is written periodically so
+// a long run can be continued with -restore after the controller dies. Next
+// is the first candidate that was never handed out to a worker.
+type checkpoint struct {
+	Version   int                    `json:"version"`
+	SavedAt   time.Time              `json:"saved_at"`
+	Jobs      []protocol.CrackingJob `json:"jobs"`
+	Next      int64                  `json:"next"`
+	Completed []keyRange             `json:"completed"`
+	Pending   []keyRange             `json:"pending"`
+	Results   []crackedHash          `json:"results"`
+	Metrics   sessionMetrics         `json:"metrics"`
+}
+
+// keyRange covers the keyspace indices [Start, End), End 0 is unbounded
+type keyRange struct {
+	JobId    int    `json:"job_id"`
+	WorkerId string `json:"worker_id,omitempty"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+}
+
+type crackedHash struct {
+	JobId    int       `json:"job_id"`
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	FoundAt  time.Time `json:"found_at"`
+}
+
+// sessionMetrics accumulate across every run of a restored session
+type sessionMetrics struct {
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	Tested     int64         `json:"tested"`
+	Heartbeats int64         `json:"heartbeats"`
+}
+
+// saveCheckpoint writes cp next to path and renames it into place, so a crash
+// mid-write leaves the previous checkpoint intact
+func saveCheckpoint(path string, cp *checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace checkpoint: %w", err)
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session file: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decode session file: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported session file version %d", cp.Version)
+	}
+	if len(cp.Jobs) == 0 {
+		return nil, fmt.Errorf("session file has no jobs")
+	}
+	return &cp, nil
+}
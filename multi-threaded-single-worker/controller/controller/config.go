@@ -0,0 +1,596 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"cracker/keyspace"
+	"cracker/logging"
+	"cracker/protocol"
+)
+
+// Attack modes a target can be cracked with
+const (
+	AttackBruteForce = "brute-force"
+	AttackWordlist   = "wordlist"
+)
+
+// Config describes a controller run. It can be read from a YAML file, where
+// relative paths are taken from the file's directory, and flags given on the
+// command line override what the file says. Logger and Events are for
+// programs that call Run themselves.
+type Config struct {
+	Listen        ListenConfig     `yaml:"listen"`
+	Heartbeat     time.Duration    `yaml:"heartbeat"`
+	ChunkDuration time.Duration    `yaml:"chunk_duration"`
+	Checkpoint    CheckpointConfig `yaml:"checkpoint"`
+	Targets       []TargetConfig   `yaml:"targets"`
+	Output        OutputConfig     `yaml:"output"`
+	Log           logging.Flags    `yaml:"log"`
+	MetricsAddr   string           `yaml:"metrics_addr"`
+	UI            bool             `yaml:"ui"`
+	KeepWorkers   bool             `yaml:"keep_workers"`
+
+	// Logger receives the run's log lines, nil logs to stdout as Log says
+	Logger *slog.Logger `yaml:"-"`
+	Events Events       `yaml:"-"`
+}
+
+type ListenConfig struct {
+	Port int       `yaml:"port"`
+	TLS  TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig turns on TLS for worker connections. With ClientCA set workers
+// must also present a certificate it signed.
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// CheckpointConfig says where and how often sessions are saved. With Resume
+// set a target whose session file exists carries on from it.
+type CheckpointConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Resume   bool          `yaml:"resume"`
+}
+
+// OutputConfig names the files written besides the session
+type OutputConfig struct {
+	Report string `yaml:"report"`
+	Record string `yaml:"record"`
+}
+
+// TargetConfig is one hash to crack, given either directly or as a user in a
+// shadow file. Charset and the lengths only apply to brute-force attacks.
+type TargetConfig struct {
+	Shadow    string `yaml:"shadow"`
+	User      string `yaml:"user"`
+	Hash      string `yaml:"hash"`
+	Attack    string `yaml:"attack"`
+	Charset   string `yaml:"charset"`
+	MinLength int    `yaml:"min_length"`
+	MaxLength int    `yaml:"max_length"`
+	Wordlist  string `yaml:"wordlist"`
+}
+
+// DefaultConfig is what a run uses for anything neither the file nor the
+// flags set
+func DefaultConfig() Config {
+	return Config{
+		ChunkDuration: 60 * time.Second,
+		Checkpoint: CheckpointConfig{
+			Path:     "controller.session",
+			Interval: 30 * time.Second,
+		},
+		Log: logging.Flags{Format: "text", Level: "info"},
+	}
+}
+
+// withDefaults fills in what a target left out
+func (t TargetConfig) withDefaults() TargetConfig {
+	if t.Attack == "" {
+		t.Attack = AttackBruteForce
+	}
+	if t.Charset == "" {
+		t.Charset = keyspace.DefaultCharset
+	}
+	if t.MinLength == 0 {
+		t.MinLength = 1
+	}
+	return t
+}
+
+// FieldError points at the setting that failed its check, by line in the
+// config file or by the flag that set it
+type FieldError struct {
+	Where string
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Where == "" {
+		return fmt.Sprintf("%s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Where, e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// configSource remembers where each setting of a Config came from
+type configSource struct {
+	file  string
+	dir   string
+	lines map[string]int
+	flags map[string]string
+}
+
+func newConfigSource() *configSource {
+	return &configSource{lines: make(map[string]int), flags: make(map[string]string)}
+}
+
+// where locates field, preferring the flag that overrode it
+func (src *configSource) where(field string) string {
+	if flag, ok := src.flags[field]; ok {
+		return "--" + flag
+	}
+	if line, ok := src.lines[field]; ok {
+		return fmt.Sprintf("%s:%d", src.file, line)
+	}
+	return ""
+}
+
+func (src *configSource) errorf(field string, format string, args ...any) error {
+	return &FieldError{Where: src.where(field), Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// path resolves a path from the config file against the file's directory
+func (src *configSource) path(field, p string) string {
+	if p == "" || filepath.IsAbs(p) || src.dir == "" {
+		return p
+	}
+	if _, fromFlag := src.flags[field]; fromFlag {
+		return p
+	}
+	return filepath.Join(src.dir, p)
+}
+
+// loadConfig reads the YAML file at path over cfg. Unknown fields are errors.
+func loadConfig(path string, cfg *Config, src *configSource) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	src.file = path
+	src.dir = filepath.Dir(path)
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err == nil {
+		recordLines(&root, "", src.lines)
+	}
+	return nil
+}
+
+// recordLines maps every field path in node, like targets[0].user, to the
+// line it is on
+func recordLines(node *yaml.Node, prefix string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			recordLines(child, prefix, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field := key.Value
+			if prefix != "" {
+				field = prefix + "." + key.Value
+			}
+			lines[field] = key.Line
+			recordLines(value, field, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			field := fmt.Sprintf("%s[%d]", prefix, i)
+			lines[field] = child.Line
+			recordLines(child, field, lines)
+		}
+	}
+}
+
+// override applies the flags set on the command line or from the
+// environment over cfg. Naming a hash, shadow file or user replaces the
+// file's targets with that one; the attack flags apply to every target.
+func (cfg *Config) override(fs *flag.FlagSet, src *configSource) {
+	set := make(map[string]flag.Value)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = f.Value })
+
+	str := func(name string, field string, dst *string) {
+		if v, ok := set[name]; ok {
+			*dst = v.String()
+			src.flags[field] = name
+		}
+	}
+	integer := func(name string, field string, dst *int) {
+		if v, ok := set[name]; ok {
+			*dst = v.(flag.Getter).Get().(int)
+			src.flags[field] = name
+		}
+	}
+	seconds := func(name string, field string, dst *time.Duration) {
+		if v, ok := set[name]; ok {
+			*dst = time.Duration(v.(flag.Getter).Get().(int)) * time.Second
+			src.flags[field] = name
+		}
+	}
+	boolean := func(name string, field string, dst *bool) {
+		if v, ok := set[name]; ok {
+			*dst = v.(flag.Getter).Get().(bool)
+			src.flags[field] = name
+		}
+	}
+
+	integer("port", "listen.port", &cfg.Listen.Port)
+	str("tls-cert", "listen.tls.cert", &cfg.Listen.TLS.Cert)
+	str("tls-key", "listen.tls.key", &cfg.Listen.TLS.Key)
+	str("tls-client-ca", "listen.tls.client_ca", &cfg.Listen.TLS.ClientCA)
+	seconds("heartbeat", "heartbeat", &cfg.Heartbeat)
+	seconds("chunk-duration", "chunk_duration", &cfg.ChunkDuration)
+	str("session", "checkpoint.path", &cfg.Checkpoint.Path)
+	seconds("checkpoint-interval", "checkpoint.interval", &cfg.Checkpoint.Interval)
+	boolean("resume", "checkpoint.resume", &cfg.Checkpoint.Resume)
+	str("report", "output.report", &cfg.Output.Report)
+	str("record", "output.record", &cfg.Output.Record)
+	str("log-format", "log", &cfg.Log.Format)
+	str("log-level", "log", &cfg.Log.Level)
+	str("metrics-addr", "metrics_addr", &cfg.MetricsAddr)
+	boolean("ui", "ui", &cfg.UI)
+	boolean("keep", "keep_workers", &cfg.KeepWorkers)
+
+	// A restored run keeps checkpointing to the file it came from unless
+	// told otherwise
+	if v, ok := set["restore"]; ok {
+		if _, ok := set["session"]; !ok {
+			cfg.Checkpoint.Path = v.String()
+			src.flags["checkpoint.path"] = "restore"
+		}
+	}
+
+	_, hash := set["hash"]
+	_, shadow := set["shadow"]
+	_, user := set["user"]
+	if hash || shadow || user {
+		cfg.Targets = []TargetConfig{{}}
+		str("hash", "targets[0].hash", &cfg.Targets[0].Hash)
+		str("shadow", "targets[0].shadow", &cfg.Targets[0].Shadow)
+		str("user", "targets[0].user", &cfg.Targets[0].User)
+	}
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		field := func(name string) string { return fmt.Sprintf("targets[%d].%s", i, name) }
+		str("attack", field("attack"), &t.Attack)
+		str("charset", field("charset"), &t.Charset)
+		integer("min-length", field("min_length"), &t.MinLength)
+		integer("max-length", field("max_length"), &t.MaxLength)
+		str("wordlist", field("wordlist"), &t.Wordlist)
+	}
+}
+
+// target is a checked TargetConfig, ready to become a session
+type target struct {
+	job     protocol.CrackingJob
+	words   []string
+	session string
+	report  string
+	restore *checkpoint
+}
+
+// plan is a checked Config with everything a run needs loaded
+type plan struct {
+	cfg     Config
+	targets []target
+	tls     *tls.Config
+}
+
+// check validates every field of cfg, loading the files it names, and
+// returns all the problems found rather than just the first. replay lifts
+// the need for a port and restore stands in for the targets.
+func check(cfg Config, src *configSource, replay bool, restore string) (*plan, error) {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, src.errorf(field, format, args...))
+	}
+
+	if !replay && (cfg.Listen.Port <= 0 || cfg.Listen.Port > 65535) {
+		fail("listen.port", "%d is not a port between 1 and 65535", cfg.Listen.Port)
+	}
+	switch {
+	case cfg.Heartbeat <= 0:
+		fail("heartbeat", "must be set and positive")
+	case cfg.Heartbeat%time.Second != 0:
+		fail("heartbeat", "%s is not a whole number of seconds", cfg.Heartbeat)
+	}
+	if cfg.ChunkDuration <= 0 {
+		fail("chunk_duration", "must be positive")
+	}
+	if cfg.Checkpoint.Path == "" {
+		fail("checkpoint.path", "must be set")
+	}
+	if cfg.Checkpoint.Interval <= 0 {
+		fail("checkpoint.interval", "must be positive")
+	}
+	if err := cfg.Log.Check(); err != nil {
+		fail("log", "%v", err)
+	}
+	if cfg.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.MetricsAddr); err != nil {
+			fail("metrics_addr", "%v", err)
+		}
+	}
+
+	p := &plan{cfg: cfg}
+
+	tlsConfig, err := checkTLS(cfg.Listen.TLS, src)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	p.tls = tlsConfig
+
+	if restore != "" {
+		cp, err := loadCheckpoint(restore)
+		if err != nil {
+			errs = append(errs, &FieldError{Where: "--restore", Field: "restore", Err: err})
+		} else {
+			tg := target{job: cp.Jobs[0], session: cfg.Checkpoint.Path, report: cfg.Output.Report, restore: cp}
+			if tg.job.Wordlist != "" {
+				if tg.words, err = loadWordlist(tg.job.Wordlist); err != nil {
+					errs = append(errs, &FieldError{Where: "--restore", Field: "wordlist", Err: err})
+				} else if int64(len(tg.words)) != tg.job.End {
+					errs = append(errs, &FieldError{Where: "--restore", Field: "wordlist", Err: fmt.Errorf("%s has changed since the session was saved", tg.job.Wordlist)})
+				}
+			}
+			p.targets = append(p.targets, tg)
+		}
+	} else if len(cfg.Targets) == 0 {
+		fail("targets", "at least one target is needed")
+	}
+
+	for i, t := range cfg.Targets {
+		if restore != "" {
+			break
+		}
+		tg, targetErrs := checkTarget(i, t.withDefaults(), src)
+		errs = append(errs, targetErrs...)
+		if len(targetErrs) > 0 {
+			continue
+		}
+		tg.session = perTarget(cfg.Checkpoint.Path, tg.job.Id, len(cfg.Targets))
+		tg.report = perTarget(cfg.Output.Report, tg.job.Id, len(cfg.Targets))
+
+		if cfg.Checkpoint.Resume {
+			if cp, err := loadCheckpoint(tg.session); err == nil {
+				if cp.Jobs[0].FullHash != tg.job.FullHash {
+					fail(fmt.Sprintf("targets[%d]", i), "session file %s holds a different hash", tg.session)
+					continue
+				}
+				tg.restore = cp
+			} else if !errors.Is(err, os.ErrNotExist) {
+				fail(fmt.Sprintf("targets[%d]", i), "%v", err)
+				continue
+			}
+		}
+		p.targets = append(p.targets, tg)
+	}
+	if replay && len(p.targets) > 1 {
+		errs = append(errs, &FieldError{Where: "--replay", Field: "targets", Err: errors.New("a replay runs a single target")})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return p, nil
+}
+
+// checkTarget validates targets[i] and loads its hash and wordlist
+func checkTarget(i int, t TargetConfig, src *configSource) (target, []error) {
+	var errs []error
+	field := func(name string) string { return fmt.Sprintf("targets[%d].%s", i, name) }
+	fail := func(name, format string, args ...any) {
+		errs = append(errs, src.errorf(field(name), format, args...))
+	}
+
+	var job *protocol.CrackingJob
+	switch {
+	case t.Hash != "" && t.Shadow != "":
+		fail("hash", "set either hash or shadow, not both")
+	case t.Hash != "":
+		user := t.User
+		if user == "" {
+			user = fmt.Sprintf("target-%d", i+1)
+		}
+		var err error
+		if job, err = protocol.ParseHash(user, t.Hash); err != nil {
+			fail("hash", "%v", err)
+		}
+	case t.Shadow != "":
+		if t.User == "" {
+			fail("user", "must be set to pick the shadow line")
+			break
+		}
+		var err error
+		if job, err = protocol.FindUserInShadow(src.path(field("shadow"), t.Shadow), t.User); err != nil {
+			fail("shadow", "%v", err)
+		}
+	default:
+		fail("hash", "set hash, or shadow and user")
+	}
+
+	var words []string
+	switch t.Attack {
+	case AttackBruteForce:
+		if t.Wordlist != "" {
+			fail("wordlist", "only used by the %s attack", AttackWordlist)
+		}
+		if len(t.Charset) < 2 {
+			fail("charset", "needs at least 2 characters")
+		}
+		if dup := repeated(t.Charset); dup != 0 {
+			fail("charset", "%q appears more than once", dup)
+		}
+		// Candidates are built a byte of the charset at a time
+		if c := nonASCII(t.Charset); c != 0 {
+			fail("charset", "%q is not an ASCII character", c)
+		}
+		if t.MinLength < 1 {
+			fail("min_length", "%d is shorter than 1", t.MinLength)
+		}
+		if t.MaxLength != 0 && t.MaxLength < t.MinLength {
+			fail("max_length", "%d is shorter than min_length %d", t.MaxLength, t.MinLength)
+		}
+		if limit := keyspace.MaxLength(len(t.Charset)); limit > 0 {
+			if t.MinLength > limit {
+				fail("min_length", "%d is longer than %d, the most a %d character charset can index", t.MinLength, limit, len(t.Charset))
+			}
+			if t.MaxLength > limit {
+				fail("max_length", "%d is longer than %d, the most a %d character charset can index", t.MaxLength, limit, len(t.Charset))
+			}
+		}
+	case AttackWordlist:
+		if t.Wordlist == "" {
+			fail("wordlist", "must be set for the %s attack", AttackWordlist)
+			break
+		}
+		var err error
+		if words, err = loadWordlist(src.path(field("wordlist"), t.Wordlist)); err != nil {
+			fail("wordlist", "%v", err)
+		}
+	default:
+		fail("attack", "%q is not %s or %s", t.Attack, AttackBruteForce, AttackWordlist)
+	}
+
+	if len(errs) > 0 {
+		return target{}, errs
+	}
+
+	job.Id = i + 1
+	if words != nil {
+		job.Wordlist = src.path(field("wordlist"), t.Wordlist)
+		job.Start, job.End = 0, int64(len(words))
+	} else {
+		job.Charset = t.Charset
+		job.Start, job.End = keyspace.Bounds(len(t.Charset), t.MinLength, t.MaxLength)
+	}
+	return target{job: *job, words: words}, nil
+}
+
+// checkTLS loads the certificates for worker connections, nil when TLS is off
+func checkTLS(c TLSConfig, src *configSource) (*tls.Config, error) {
+	if c.Cert == "" && c.Key == "" && c.ClientCA == "" {
+		return nil, nil
+	}
+	if c.Cert == "" || c.Key == "" {
+		return nil, src.errorf("listen.tls", "cert and key must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(src.path("listen.tls.cert", c.Cert), src.path("listen.tls.key", c.Key))
+	if err != nil {
+		return nil, src.errorf("listen.tls.cert", "%v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if c.ClientCA != "" {
+		pem, err := os.ReadFile(src.path("listen.tls.client_ca", c.ClientCA))
+		if err != nil {
+			return nil, src.errorf("listen.tls.client_ca", "%v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, src.errorf("listen.tls.client_ca", "no PEM certificates found")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadWordlist reads one candidate per line, skipping empty lines
+func loadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimRight(scanner.Text(), "\r"); word != "" {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%s has no words", path)
+	}
+	return words, nil
+}
+
+// repeated returns a character charset holds more than once, or 0
+func repeated(charset string) rune {
+	seen := make(map[rune]bool)
+	for _, c := range charset {
+		if seen[c] {
+			return c
+		}
+		seen[c] = true
+	}
+	return 0
+}
+
+// nonASCII returns the first character of charset outside ASCII, or 0
+func nonASCII(charset string) rune {
+	for _, c := range charset {
+		if c >= utf8.RuneSelf {
+			return c
+		}
+	}
+	return 0
+}
+
+// perTarget gives each of several targets its own file by putting the job id
+// before the extension, e.g. run.2.json
+func perTarget(path string, jobId int, targets int) string {
+	if path == "" || targets <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + strconv.Itoa(jobId) + ext
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cracker/keyspace"
+)
+
+func TestCheckTarget(t *testing.T) {
+	limit := keyspace.MaxLength(len(sampleCharset))
+	cases := []struct {
+		name   string
+		target TargetConfig
+		field  string
+		reason string
+	}{
+		{"valid", TargetConfig{MinLength: 1, MaxLength: limit}, "", ""},
+		{"max_length past the index limit", TargetConfig{MaxLength: limit + 1}, "max_length", "longer than"},
+		{"min_length past the index limit", TargetConfig{MinLength: limit + 1}, "min_length", "longer than"},
+		{"non-ASCII charset", TargetConfig{Charset: "ABCé"}, "charset", "not an ASCII character"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.target.Hash = "$1$salt$hash"
+			if tc.target.Charset == "" {
+				tc.target.Charset = sampleCharset
+			}
+			_, errs := checkTarget(0, tc.target.withDefaults(), newConfigSource())
+
+			if tc.field == "" {
+				if len(errs) > 0 {
+					t.Fatalf("got %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("got %v, want one error for %s", errs, tc.field)
+			}
+			var fe *FieldError
+			if !errors.As(errs[0], &fe) || fe.Field != "targets[0]."+tc.field {
+				t.Fatalf("got %v, want a FieldError for targets[0].%s", errs[0], tc.field)
+			}
+			if !strings.Contains(fe.Error(), tc.reason) {
+				t.Fatalf("got %q, want it to say %q", fe.Error(), tc.reason)
+			}
+		})
+	}
+}
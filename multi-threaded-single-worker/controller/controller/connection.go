@@ -0,0 +1,280 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"cracker/protocol"
+	"cracker/record"
+)
+
+// ResultMsg is how a target's run ended. Interrupted holds why the run was
+// cancelled when that came before a result.
+type ResultMsg struct {
+	Password    string
+	Metrics     *Metrics
+	Err         error
+	Interrupted error
+}
+
+// Workers answer every heartbeat, so one silent for this many intervals is
+// taken for dead and its chunk handed to another
+const heartbeatTimeoutBeats = 3
+
+// errWorkerFailed ends the connection to a worker that reported it cannot
+// crack its chunk
+var errWorkerFailed = errors.New("worker reported failure")
+
+type Metrics struct {
+	JobDispatch  time.Duration
+	WorkerCrack  time.Duration
+	ResultReturn time.Duration
+}
+
+// handleWorkerConnection serves one worker connection until it drops. The
+// worker's range stays with the session so it can pick it up again when it
+// reconnects, unless the worker went silent or failed, which hands the range
+// on.
+func handleWorkerConnection(conn net.Conn, sess *session, interval int, resultCh chan<- ResultMsg, rec *record.Recorder, log *slog.Logger) {
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	writeCh := make(chan protocol.Message, 16)
+	closed := make(chan struct{})
+
+	peer := conn.RemoteAddr().String()
+	encoder := rec.Encoder(json.NewEncoder(conn), peer)
+	silence := time.Duration(heartbeatTimeoutBeats*interval) * time.Second
+	decoder := rec.Decoder(json.NewDecoder(silenceLimit{conn, silence}), peer)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		writeRequests(encoder, interval, writeCh, closed, log)
+	}()
+
+	go func() {
+		defer wg.Done()
+		select {
+		case <-sess.released:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	workerId, err := readRequests(decoder, sess, writeCh, resultCh, log)
+	close(closed)
+	wg.Wait()
+
+	switch {
+	case workerId == "":
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		log.Warn("worker silent, handing its chunk on", "worker_id", workerId, "silence", silence)
+		sess.left(workerId, writeCh)
+	case errors.Is(err, errWorkerFailed):
+		log.Warn("worker failed, handing its chunk on", "worker_id", workerId, "err", err)
+		sess.left(workerId, writeCh)
+	default:
+		sess.disconnected(workerId, writeCh)
+		log.Info("worker disconnected", "worker_id", workerId)
+	}
+}
+
+// silenceLimit fails a read once the worker has sent nothing for limit
+type silenceLimit struct {
+	conn  net.Conn
+	limit time.Duration
+}
+
+func (r silenceLimit) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.limit))
+	return r.conn.Read(p)
+}
+
+// writeRequests keeps draining writeCh after a write error so the reader
+// never blocks on a dead connection; it exits once the reader is done
+func writeRequests(encoder protocol.Encoder, interval int, writeCh <-chan protocol.Message, closed <-chan struct{}, log *slog.Logger) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	var failed bool
+	for {
+		select {
+		case <-closed:
+			log.Debug("connection closed, writer exiting")
+			return
+
+		case msg := <-writeCh:
+			if failed {
+				continue
+			}
+			if err := encoder.Encode(msg); err != nil {
+				log.Warn("write error", "command", msg.Command, "err", err)
+				failed = true
+			}
+
+		case <-ticker.C:
+			if failed {
+				continue
+			}
+			heartbeat := protocol.Message{
+				Command: protocol.MsgHeartbeat,
+			}
+			if err := encoder.Encode(heartbeat); err != nil {
+				log.Warn("heartbeat send failed", "err", err)
+				failed = true
+				continue
+			}
+			log.Debug("-> heartbeat sent", "command", protocol.MsgHeartbeat)
+		}
+	}
+}
+
+// dispatchJob sends the worker its next chunk
+func dispatchJob(sess *session, hello *protocol.WorkerHello, writeCh chan<- protocol.Message, log *slog.Logger) error {
+	job, err := sess.assign(hello, writeCh)
+	if err != nil {
+		return err
+	}
+
+	log.Info("-> enqueue cracking job", "worker_id", hello.WorkerId, "start", job.Start, "end", job.End)
+	writeCh <- protocol.Message{
+		Command: protocol.MsgJob,
+		Job:     job,
+	}
+	return nil
+}
+
+// readRequests handles messages from one worker until the connection fails or
+// the worker reports an error, and returns the id the worker introduced itself
+// with and why it stopped
+func readRequests(decoder protocol.Decoder, sess *session, writeCh chan<- protocol.Message, resultCh chan<- ResultMsg, log *slog.Logger) (string, error) {
+	var jobSentTime time.Time
+	var workerId string
+	var hello *protocol.WorkerHello
+
+	report := func(res ResultMsg) {
+		select {
+		case resultCh <- res:
+		case <-sess.done:
+		}
+	}
+
+	for {
+		var msg protocol.Message
+		if err := decoder.Decode(&msg); err != nil {
+			log.Info("decode worker message", "worker_id", workerId, "err", err)
+			return workerId, err
+		}
+
+		log.Debug("<- command received", "worker_id", workerId, "command", msg.Command)
+
+		// A message that does not carry what its command needs is dropped,
+		// the worker's next heartbeat or result puts things right
+		err := msg.ValidateFromWorker()
+		if err == nil && hello == nil && msg.Command != protocol.MsgReady && msg.Command != protocol.MsgError {
+			err = &protocol.MessageError{Command: msg.Command, Err: protocol.ErrOutOfOrder, Detail: "the worker has not sent ready"}
+		}
+		if err != nil {
+			log.Warn("<- invalid worker message", "worker_id", workerId, "err", err)
+			continue
+		}
+
+		switch msg.Command {
+
+		case protocol.MsgReady:
+			hello = msg.Hello
+			if hello == nil || hello.WorkerId == "" {
+				hello = &protocol.WorkerHello{WorkerId: fmt.Sprintf("anonymous-%p", writeCh)}
+			}
+
+			jobSentTime = time.Now()
+			if err := dispatchJob(sess, hello, writeCh, log); err != nil {
+				log.Warn("-> rejecting worker", "worker_id", hello.WorkerId, "err", err)
+				writeCh <- protocol.Message{Command: protocol.MsgError, Error: err.Error()}
+				continue
+			}
+			workerId = hello.WorkerId
+
+		case protocol.MsgResult:
+			result := msg.Result
+			crackTime := time.Duration(result.Metrics.TotalCrackingTimeNanos)
+
+			if result.Cancelled {
+				log.Info("<- worker cancelled job", "worker_id", workerId, "last_completed", result.LastCompleted)
+				sess.cancelAcked(workerId, result.LastCompleted)
+				continue
+			}
+
+			// Chunk searched without a match, move the worker on to the next
+			if result.Password == "" {
+				log.Info("<- worker finished its chunk", "worker_id", workerId, "crack_time", crackTime)
+				if sess.chunkDone(workerId, crackTime) {
+					report(ResultMsg{Metrics: &Metrics{WorkerCrack: crackTime}})
+					continue
+				}
+
+				jobSentTime = time.Now()
+				if err := dispatchJob(sess, hello, writeCh, log); err != nil {
+					log.Info("worker idle", "worker_id", workerId, "reason", err)
+				}
+				continue
+			}
+
+			log.Info("<- received cracking result", "worker_id", workerId)
+			jobReceiveTime := time.Now()
+
+			metrics := Metrics{
+				WorkerCrack:  crackTime,
+				JobDispatch:  result.Metrics.WorkerReceiveJobNanos.Sub(jobSentTime),
+				ResultReturn: jobReceiveTime.Sub(result.Metrics.WorkerSentResultsNanos),
+			}
+
+			sess.cancelJob(workerId)
+			report(ResultMsg{
+				Metrics:  &metrics,
+				Password: result.Password,
+			})
+
+		case protocol.MsgError:
+			// One worker failing says nothing of the others, so the run goes
+			// on without it
+			return workerId, fmt.Errorf("worker %s: %s: %w", workerId, msg.Error, errWorkerFailed)
+
+		case protocol.MsgHeartbeat:
+			hb := msg.Heartbeat
+			sess.progress(workerId, hb)
+			log.Debug("heartbeat",
+				"worker_id", workerId,
+				"delta", hb.DeltaTested,
+				"total", hb.TotalTested,
+				"threads", hb.ThreadsActive,
+				"rate", hb.CurrentRate,
+				"window", time.Duration(hb.WindowNanos),
+				"cpu_seconds", hb.CPUSeconds,
+				"rss_bytes", hb.RSSBytes,
+				"last_completed", hb.LastCompleted,
+			)
+
+		case protocol.MsgLeave:
+			if hb := msg.Heartbeat; hb != nil {
+				sess.progress(workerId, hb)
+			}
+			log.Info("<- worker leaving", "worker_id", workerId)
+			sess.left(workerId, writeCh)
+
+			// Let it go; its range is with another worker or waiting for one
+			writeCh <- protocol.Message{Command: protocol.MsgShutdown}
+
+		default:
+			log.Warn("unknown worker status", "worker_id", workerId, "command", msg.Command)
+
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+// FuzzReadRequests feeds the controller's reader whatever a worker might
+// send, one JSON message after another, and checks it neither panics nor
+// leaves the session inconsistent
+func FuzzReadRequests(f *testing.F) {
+	f.Add([]byte(`{"command":"ready","hello":{"worker_id":"w1","threads":2}}
+{"command":"heartbeat","heartbeat":{"delta_tested":5,"total_tested":5,"current_rate":50,"job_start":0,"last_completed":4}}
+{"command":"result","result":{"job_id":1,"password":"","metrics":{"total_cracking_time_ns":1000000}}}`))
+	f.Add([]byte(`{"command":"ready"}
+{"command":"result","result":{"job_id":1,"password":"ACE"}}`))
+	f.Add([]byte(`{"command":"ready","hello":{"worker_id":"w1","resume":{"job_id":1,"last_completed":7}}}
+{"command":"leave","heartbeat":{"total_tested":3}}`))
+	f.Add([]byte(`{"command":"result"}{"command":"heartbeat"}{"command":"job"}`))
+	f.Add([]byte(`{"command":"result","result":{"cancelled":true,"last_completed":99}}`))
+
+	charset := "ABC"
+	job := protocol.CrackingJob{Id: 1, Interval: 1, Username: "fuzz", Setting: "$1$salt", FullHash: "$1$salt$hash", Charset: charset}
+	job.Start, job.End = keyspace.Bounds(len(charset), 1, 4)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sess := newSession(job, time.Second)
+		writeCh := make(chan protocol.Message, 16)
+		resultCh := make(chan ResultMsg)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-writeCh:
+				case <-resultCh:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		readRequests(json.NewDecoder(bytes.NewReader(data)), sess, writeCh, resultCh, log)
+
+		cp := sess.snapshot()
+		for _, r := range append(cp.Completed, cp.Pending...) {
+			if r.Start < job.Start || (r.End != 0 && (r.End < r.Start || r.End > job.End)) {
+				t.Fatalf("range [%d, %d) outside the job [%d, %d)", r.Start, r.End, job.Start, job.End)
+			}
+		}
+		if p := sess.updateProgress(); p.Tested < 0 {
+			t.Fatalf("negative tested count %d", p.Tested)
+		}
+	})
+}
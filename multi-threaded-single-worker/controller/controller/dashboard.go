@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cracker/logging"
+)
+
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+
+	tailLines = 8
+)
+
+// isTerminal reports whether f is attached to a terminal rather than a file
+// or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// logTail keeps the last few log lines so they can be drawn under the
+// dashboard instead of scrolling through it
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+	if len(t.lines) > tailLines {
+		t.lines = t.lines[len(t.lines)-tailLines:]
+	}
+	return len(p), nil
+}
+
+func (t *logTail) last() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string{}, t.lines...)
+}
+
+// dashboard redraws a full-screen summary of the run on every heartbeat
+// cycle, with the log reduced to a short tail at the bottom
+type dashboard struct {
+	out  io.Writer
+	sess *session
+	log  *logging.Output
+	tail logTail
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startDashboard takes over the terminal and the log output until close
+func startDashboard(out io.Writer, sess *session, interval time.Duration, log *logging.Output) *dashboard {
+	d := &dashboard{out: out, sess: sess, log: log, stop: make(chan struct{})}
+	log.SetOutput(&d.tail)
+	fmt.Fprint(out, enterScreen)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			d.draw()
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return d
+}
+
+// close gives the terminal and the log back
+func (d *dashboard) close() {
+	close(d.stop)
+	d.wg.Wait()
+	fmt.Fprint(d.out, leaveScreen)
+	d.log.SetOutput(os.Stdout)
+}
+
+func (d *dashboard) draw() {
+	v := d.sess.view()
+	var b bytes.Buffer
+
+	fmt.Fprint(&b, clearScreen)
+	fmt.Fprintf(&b, "Unix Password Cracker | job %d | user %s | elapsed %s\n", v.Job.Id, v.Job.Username, v.Elapsed.Round(time.Second))
+	fmt.Fprintf(&b, "hash %s\n\n", v.Job.FullHash)
+
+	fmt.Fprintf(&b, "%-24s %-6s %8s %14s %14s %7s %9s %10s\n", "WORKER", "STATE", "THREADS", "RATE/s", "TESTED", "CPU%", "RSS MiB", "LAST HB")
+	for _, w := range v.Workers {
+		state := "gone"
+		if w.Connected {
+			state = "up"
+		}
+		lastHb := "-"
+		if !w.LastHeartbeat.IsZero() {
+			lastHb = time.Since(w.LastHeartbeat).Round(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(&b, "%-24s %-6s %3d/%-4d %14.2f %14d %7.1f %9.1f %10s\n", w.WorkerId, state, w.ThreadsActive, w.Threads, w.Rate, w.Tested, w.CPUPercent, float64(w.RSSBytes)/(1<<20), lastHb)
+	}
+	if len(v.Workers) == 0 {
+		fmt.Fprintln(&b, "waiting for workers...")
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "Keyspace: %d dispatched", v.Dispatched)
+	if v.Job.End != 0 {
+		fmt.Fprintf(&b, " of %d", v.Job.End-v.Job.Start)
+	}
+	fmt.Fprintf(&b, "\nProgress: %s\n", v.Progress)
+	if v.Progress.HasPercent {
+		fmt.Fprintf(&b, "          [%s]\n", progressBar(v.Progress.Percent, 50))
+	}
+	fmt.Fprintf(&b, "Cracked:  %d\n", len(v.Results))
+	for _, r := range v.Results {
+		fmt.Fprintf(&b, "  %s: %s\n", r.Username, r.Password)
+	}
+
+	fmt.Fprintln(&b, "\n---- log ----")
+	for _, line := range d.tail.last() {
+		fmt.Fprintln(&b, line)
+	}
+
+	d.out.Write(b.Bytes())
+}
+
+func progressBar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	return strings.Repeat("#", filled) + strings.Repeat(".", width-filled)
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cracker/chaos"
+	"cracker/keyspace"
+	"cracker/protocol"
+	"cracker/worker"
+)
+
+// Every password in the sample shadow files is spelled with these
+const sampleCharset = "ABCDER"
+
+const harnessTimeout = 30 * time.Second
+
+// harness runs a controller session with workers in the same process, each
+// worker talking to the controller over its own net.Pipe
+type harness struct {
+	t        *testing.T
+	sess     *session
+	resultCh chan ResultMsg
+	log      *slog.Logger
+	wg       sync.WaitGroup
+}
+
+func newHarness(t *testing.T, job protocol.CrackingJob) *harness {
+	t.Helper()
+	job.Interval = 1
+	return &harness{
+		t:        t,
+		sess:     newSession(job, time.Second),
+		resultCh: make(chan ResultMsg),
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// addWorker connects a worker with the given id and thread count
+func (h *harness) addWorker(id string, threads int) {
+	h.addFaultyWorker(id, threads, chaos.Faults{}, chaos.Faults{})
+}
+
+// addFaultyWorker connects a worker whose end of the connection suffers
+// workerFaults and whose controller's end suffers controllerFaults
+func (h *harness) addFaultyWorker(id string, threads int, workerFaults chaos.Faults, controllerFaults chaos.Faults) {
+	var controllerEnd, workerEnd net.Conn
+	controllerEnd, workerEnd = net.Pipe()
+	if !controllerFaults.Zero() {
+		controllerEnd = chaos.Wrap(controllerEnd, controllerFaults)
+	}
+	if !workerFaults.Zero() {
+		workerEnd = chaos.Wrap(workerEnd, workerFaults)
+	}
+
+	h.wg.Add(2)
+	go func() {
+		defer h.wg.Done()
+		handleWorkerConnection(controllerEnd, h.sess, h.sess.job.Interval, h.resultCh, nil, h.log)
+	}()
+	go func() {
+		defer h.wg.Done()
+		defer workerEnd.Close()
+
+		// A worker that asks once the keyspace is all handed out is turned
+		// away and hangs up, which is fine here
+		worker.Serve(context.Background(), workerEnd, protocol.WorkerHello{WorkerId: id, Threads: threads}, threads, h.log)
+	}()
+}
+
+// connect serves a new connection the way the controller does and returns
+// the worker's end of it
+func (h *harness) connect() net.Conn {
+	controllerEnd, workerEnd := net.Pipe()
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		handleWorkerConnection(controllerEnd, h.sess, h.sess.job.Interval, h.resultCh, nil, h.log)
+	}()
+	return workerEnd
+}
+
+// waitFor polls the session until cond, which is called with s.mu held,
+// holds
+func (h *harness) waitFor(what string, cond func(s *session) bool) {
+	h.t.Helper()
+	deadline := time.Now().Add(harnessTimeout)
+	for {
+		h.sess.mu.Lock()
+		ok := cond(h.sess)
+		h.sess.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("gave up waiting for %s after %s", what, harnessTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// run waits for the session's result, then shuts the workers down and waits
+// for them the way the controller does
+func (h *harness) run() ResultMsg {
+	h.t.Helper()
+
+	var result ResultMsg
+	select {
+	case result = <-h.resultCh:
+	case <-time.After(harnessTimeout):
+		h.t.Fatalf("no result after %s", harnessTimeout)
+	}
+	h.sess.finish()
+	if result.Password != "" {
+		h.sess.cracked(result.Password)
+		h.sess.awaitCancelled(2 * time.Second)
+	}
+	h.sess.broadcast(protocol.Message{Command: protocol.MsgShutdown})
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(harnessTimeout):
+		h.t.Fatalf("workers still running %s after shutdown", harnessTimeout)
+	}
+	return result
+}
+
+// bruteForceJob reads the sample user's hash from a shadow file in
+// passwords/ and searches candidates of exactly length characters
+func bruteForceJob(t *testing.T, shadow string, charset string, length int) protocol.CrackingJob {
+	t.Helper()
+	job, err := protocol.FindUserInShadow(filepath.Join("..", "..", "passwords", shadow), "aryan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Id = 1
+	job.Charset = charset
+	job.Start, job.End = keyspace.Bounds(len(charset), length, length)
+	return *job
+}
+
+func TestHarnessCracksSampleShadows(t *testing.T) {
+	for _, password := range []string{"ACE", "BAD", "CAB", "DAD", "EAR"} {
+		for _, algorithm := range []string{"md5", "sha256", "sha512", "yescrypt", "bcrypt"} {
+			shadow := fmt.Sprintf("shadow_%s_%s", password, algorithm)
+			t.Run(shadow, func(t *testing.T) {
+				t.Parallel()
+				h := newHarness(t, bruteForceJob(t, shadow, sampleCharset, 3))
+				h.addWorker("w1", 2)
+
+				result := h.run()
+				if result.Password != password {
+					t.Fatalf("got password %q, want %q", result.Password, password)
+				}
+				if r, ok := h.sess.result(); !ok || r.Password != password {
+					t.Fatalf("session result %+v, %v", r, ok)
+				}
+			})
+		}
+	}
+}
+
+func TestHarnessSplitsKeyspaceAcrossWorkers(t *testing.T) {
+	// EAR sits in the last chunk, so the others are handed out first
+	h := newHarness(t, bruteForceJob(t, "shadow_EAR_md5", "RDCBAE", 3))
+	h.addWorker("w1", 1)
+	h.addWorker("w2", 1)
+	h.addWorker("w3", 1)
+
+	result := h.run()
+	if result.Password != "EAR" {
+		t.Fatalf("got password %q, want EAR", result.Password)
+	}
+
+	v := h.sess.view()
+	if len(v.Workers) != 3 {
+		t.Fatalf("%d workers took part, want 3", len(v.Workers))
+	}
+}
+
+func TestHarnessExhaustsKeyspace(t *testing.T) {
+	// No E or R in the charset, so ACE and friends are out of reach
+	job := bruteForceJob(t, "shadow_ACE_md5", "ABCD", 3)
+	h := newHarness(t, job)
+	h.addWorker("w1", 2)
+	h.addWorker("w2", 2)
+
+	result := h.run()
+	if result.Password != "" || result.Err != nil {
+		t.Fatalf("got %+v, want the keyspace exhausted", result)
+	}
+
+	cp := h.sess.snapshot()
+	if len(cp.Pending) != 0 {
+		t.Fatalf("pending ranges left: %+v", cp.Pending)
+	}
+	var covered int64
+	for _, r := range cp.Completed {
+		covered += r.End - r.Start
+	}
+	if size := keyspaceSize(job); covered != size {
+		t.Fatalf("completed %d candidates, want %d", covered, size)
+	}
+}
+
+func TestHarnessWordlist(t *testing.T) {
+	job := bruteForceJob(t, "shadow_DAD_sha256", sampleCharset, 3)
+	words := []string{"ACE", "BAD", "CAB", "DAD", "EAR"}
+	job.Charset = ""
+	job.Wordlist = "words.txt"
+	job.Start, job.End = 0, int64(len(words))
+
+	h := newHarness(t, job)
+	h.sess.words = words
+	h.addWorker("w1", 2)
+
+	if result := h.run(); result.Password != "DAD" {
+		t.Fatalf("got password %q, want DAD", result.Password)
+	}
+}
+
+func TestHarnessHandsOnFailedWorkersChunk(t *testing.T) {
+	h := newHarness(t, bruteForceJob(t, "shadow_EAR_md5", sampleCharset, 3))
+
+	// A worker asking for enough threads to get the whole keyspace in one
+	// chunk, which then fails to crack it
+	conn := h.connect()
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	conn.SetDeadline(time.Now().Add(harnessTimeout))
+	if err := enc.Encode(protocol.Message{Command: protocol.MsgReady, Hello: &protocol.WorkerHello{WorkerId: "broken", Threads: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	var msg protocol.Message
+	for msg.Command != protocol.MsgJob {
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encode(protocol.Message{Command: protocol.MsgError, Error: "crypt failed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The controller hangs up on it, the run goes on
+	for dec.Decode(&msg) == nil {
+		// Heartbeats sent before the hang-up
+	}
+	conn.Close()
+
+	h.addWorker("w1", 2)
+	if result := h.run(); result.Password != "EAR" || result.Err != nil {
+		t.Fatalf("got %+v, want EAR found by the working worker", result)
+	}
+}
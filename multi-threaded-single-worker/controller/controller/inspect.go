@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"cracker/protocol"
+	"cracker/record"
+)
+
+// Show prints the passwords a session file holds as user:password lines
+func Show(w io.Writer, path string) error {
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		return err
+	}
+	for _, r := range cp.Results {
+		fmt.Fprintf(w, "%s:%s\n", r.Username, r.Password)
+	}
+	fmt.Fprintf(w, "%d password hashes cracked, %d left\n", len(cp.Results), len(cp.Jobs)-len(cp.Results))
+	return nil
+}
+
+// Inspect summarizes a session file, a run report or a protocol recording,
+// telling them apart by their content
+func Inspect(w io.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		// Several JSON values in a row is a recording
+		if bytes.Count(bytes.TrimSpace(data), []byte("\n")) > 0 {
+			return inspectRecording(w, path)
+		}
+		return fmt.Errorf("%s is not a session, report or recording: %w", path, err)
+	}
+
+	switch {
+	case probe["version"] != nil && probe["jobs"] != nil:
+		cp, err := loadCheckpoint(path)
+		if err != nil {
+			return err
+		}
+		inspectCheckpoint(w, cp)
+	case probe["rate_samples"] != nil:
+		var r runReport
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("decode report: %w", err)
+		}
+		inspectReport(w, &r)
+	case probe["direction"] != nil:
+		return inspectRecording(w, path)
+	default:
+		return fmt.Errorf("%s is not a session, report or recording", path)
+	}
+	return nil
+}
+
+func inspectJob(w io.Writer, job protocol.CrackingJob) {
+	fmt.Fprintf(w, "Job %d: user %s, %s\n", job.Id, job.Username, protocol.Algorithm(job.Setting))
+	fmt.Fprintf(w, "  hash:     %s\n", job.FullHash)
+	if size := keyspaceSize(job); size > 0 {
+		fmt.Fprintf(w, "  keyspace: [%d, %d), %d candidates\n", job.Start, job.End, size)
+	} else {
+		fmt.Fprintf(w, "  keyspace: unbounded from %d\n", job.Start)
+	}
+}
+
+func inspectCheckpoint(w io.Writer, cp *checkpoint) {
+	fmt.Fprintf(w, "Session file, version %d, saved %s\n", cp.Version, cp.SavedAt.Format(time.RFC3339))
+	for _, job := range cp.Jobs {
+		inspectJob(w, job)
+	}
+
+	var completed int64
+	for _, r := range cp.Completed {
+		completed += r.End - r.Start
+	}
+	fmt.Fprintf(w, "Dispatched up to:  %d\n", cp.Next)
+	fmt.Fprintf(w, "Completed ranges:  %d, %d candidates\n", len(cp.Completed), completed)
+	fmt.Fprintf(w, "Pending ranges:    %d\n", len(cp.Pending))
+	for _, r := range cp.Pending {
+		fmt.Fprintf(w, "  [%d, %d) %s\n", r.Start, r.End, r.WorkerId)
+	}
+	fmt.Fprintf(w, "Tested:            %d\n", cp.Metrics.Tested)
+	fmt.Fprintf(w, "Heartbeats:        %d\n", cp.Metrics.Heartbeats)
+	fmt.Fprintf(w, "Runtime:           %s\n", cp.Metrics.Elapsed.Round(time.Millisecond))
+	for _, r := range cp.Results {
+		fmt.Fprintf(w, "Cracked:           %s:%s at %s\n", r.Username, r.Password, r.FoundAt.Format(time.RFC3339))
+	}
+}
+
+func inspectReport(w io.Writer, r *runReport) {
+	fmt.Fprintln(w, "Run report")
+	fmt.Fprintf(w, "Job %d: user %s, %s\n", r.Job.Id, r.Job.Username, r.Job.Algorithm)
+	if r.Job.Wordlist != "" {
+		fmt.Fprintf(w, "Attack:     %s, %s\n", r.Job.Attack, r.Job.Wordlist)
+	} else if r.Job.Attack != "" {
+		fmt.Fprintf(w, "Attack:     %s\n", r.Job.Attack)
+	}
+	switch {
+	case r.Result.Found:
+		fmt.Fprintf(w, "Result:     found %q\n", r.Result.Password)
+	case r.Result.Interrupted != "":
+		fmt.Fprintf(w, "Result:     interrupted by %s\n", r.Result.Interrupted)
+	default:
+		fmt.Fprintln(w, "Result:     not found")
+	}
+	fmt.Fprintf(w, "End to end: %s\n", r.Metrics.EndToEnd.Round(time.Millisecond))
+	fmt.Fprintf(w, "Tested:     %d\n", r.Metrics.Tested)
+
+	var peak, sum float64
+	for _, s := range r.Samples {
+		peak = max(peak, s.Rate)
+		sum += s.Rate
+	}
+	if len(r.Samples) > 0 {
+		fmt.Fprintf(w, "Heartbeats: %d, mean rate %.2f/sec, peak %.2f/sec\n", len(r.Samples), sum/float64(len(r.Samples)), peak)
+	}
+	for _, wk := range r.Workers {
+		fmt.Fprintf(w, "Worker %s: %d threads, %d tested, last rate %.2f/sec\n", wk.WorkerId, wk.Threads, wk.Tested, wk.LastRate)
+	}
+}
+
+func inspectRecording(w io.Writer, path string) error {
+	entries, err := record.Load(path)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "Empty recording")
+		return nil
+	}
+
+	first, last := entries[0], entries[len(entries)-1]
+	fmt.Fprintf(w, "Recording by the %s, %d messages over %s\n", first.Side, len(entries), last.Time.Sub(first.Time).Round(time.Millisecond))
+
+	type key struct {
+		peer      string
+		direction string
+		command   protocol.Command
+	}
+	counts := make(map[key]int)
+	for _, e := range entries {
+		counts[key{e.Peer, e.Direction, e.Message.Command}]++
+	}
+	keys := make([]key, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.peer != b.peer {
+			return a.peer < b.peer
+		}
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		return a.command < b.command
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-24s %-4s %-10s %d\n", k.peer, k.direction, k.command, counts[k])
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cracker/chaos"
+	"cracker/cli"
+	"cracker/keyspace"
+	"cracker/logging"
+	"cracker/protocol"
+	"cracker/record"
+)
+
+func humanDuration(d time.Duration) string {
+	abs := d.Abs()
+
+	switch {
+	case abs < time.Microsecond:
+		return fmt.Sprintf("%d ns", d.Nanoseconds())
+	case abs < time.Second:
+		return fmt.Sprintf("%.3f ms", float64(d.Microseconds())/1000)
+	default:
+		return fmt.Sprintf("%.3f s", d.Seconds())
+	}
+}
+
+// fatal logs msg as an error and exits
+func fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
+}
+
+const (
+	synopsis    = "[--config FILE] (--port PORT | --replay RECORDING) --heartbeat SECONDS (--shadow FILE --user USERNAME | --hash HASH | --restore SESSION) [flags]"
+	description = "Serves cracking jobs to workers, handing each target's keyspace out in chunks until its password is found or the keyspace runs out.\n" +
+		"Settings can come from a YAML config file, which may list several targets; flags override it."
+)
+
+// Main runs the controller subcommand with the arguments after its name
+func Main(args []string) {
+	start := time.Now()
+
+	defaults := DefaultConfig()
+	fs := cli.NewFlagSet("controller", synopsis, description)
+	configFile := fs.String("config", "", "YAML file to read settings from, flags override what it says")
+	fs.Int("port", 0, "port to listen for workers on")
+	fs.String("user", "", "user whose password to crack")
+	fs.String("shadow", "", "shadow file to read the user's hash from")
+	fs.String("hash", "", "crypt hash to crack, instead of a shadow file")
+	fs.String("attack", AttackBruteForce, "attack to run, brute-force or wordlist")
+	fs.String("wordlist", "", "file of candidates, one per line, for the wordlist attack")
+	fs.Int("heartbeat", 0, "heartbeat interval in seconds")
+	fs.String("session", defaults.Checkpoint.Path, "session file to checkpoint to")
+	fs.Int("checkpoint-interval", int(defaults.Checkpoint.Interval.Seconds()), "checkpoint interval in seconds")
+	fs.Bool("resume", false, "continue each target from its session file when there is one")
+	fs.Int("chunk-duration", int(defaults.ChunkDuration.Seconds()), "target duration of a work chunk in seconds")
+	fs.String("charset", keyspace.DefaultCharset, "characters to build candidates from")
+	fs.Int("min-length", 1, "shortest candidate length")
+	fs.Int("max-length", 0, "longest candidate length, 0 for no limit")
+	restore := fs.String("restore", "", "session file to continue from")
+	fs.String("metrics-addr", "", "address to serve Prometheus metrics and pprof on, e.g. localhost:9090")
+	fs.Bool("ui", false, "show a live dashboard instead of log lines when stdout is a terminal")
+	fs.Bool("keep", false, "leave workers running for the next controller instead of shutting them down")
+	fs.String("report", "", "write a run report to this file, CSV if it ends in .csv and JSON otherwise")
+	fs.String("record", "", "record every message exchanged with workers to this JSONL file")
+	fs.String("tls-cert", "", "certificate to serve workers TLS with")
+	fs.String("tls-key", "", "private key for --tls-cert")
+	fs.String("tls-client-ca", "", "CA that must have signed the workers' certificates")
+	replayFile := fs.String("replay", "", "play the worker side of a recording into the controller instead of listening")
+	replayPeer := fs.String("replay-peer", "", "connection in the recording to replay, the first one by default")
+	replayRealTime := fs.Bool("replay-realtime", false, "replay messages no faster than they were recorded instead of as fast as the controller keeps up")
+	debugFaults := fs.String("debug-faults", "", "inject faults into worker connections, e.g. latency=50ms,read.drop=0.1,close-after=20 (for testing)")
+	var logFlags logging.Flags
+	logFlags.Register(fs)
+
+	if err := cli.Parse(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	faults, err := chaos.Parse(*debugFaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--debug-faults: %v\n", err)
+		os.Exit(2)
+	}
+	// Check everything up front, so a mistake in the last target does not
+	// surface after the first has run for hours
+	parseStart := time.Now()
+	cfg := DefaultConfig()
+	src := newConfigSource()
+	if *configFile != "" {
+		if err := loadConfig(*configFile, &cfg, src); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	cfg.override(fs, src)
+	p, err := check(cfg, src, *replayFile != "", *restore)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "run 'cracker controller -h' for the flags")
+		os.Exit(2)
+	}
+	parseTime := time.Since(parseStart)
+
+	logOutput := logging.NewOutput(os.Stdout)
+	log, err := logging.New(logOutput, cfg.Log, "controller")
+	if err != nil {
+		fatal(slog.Default(), "failed to set up logging", "err", err)
+	}
+	p.cfg.Logger = log
+
+	var replay []record.Entry
+	if *replayFile != "" {
+		replay, err = record.Load(*replayFile)
+		if err != nil {
+			fatal(log, "failed to load recording", "err", err)
+		}
+	}
+
+	// Ctrl-C or a TERM stops the workers, saves the session and reports what
+	// was done so far; a second one kills the controller the usual way
+	ctx, interrupt := context.WithCancelCause(context.Background())
+	defer interrupt(nil)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var interrupted atomic.Value
+	go func() {
+		select {
+		case sig := <-signals:
+			interrupted.Store(sig)
+			interrupt(errors.New(sig.String()))
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	p.cfg.Events.Result = func(r Result) {
+		printResults(len(p.targets), r, parseTime)
+	}
+	opts := runOptions{
+		start:          start,
+		parseTime:      parseTime,
+		logOutput:      logOutput,
+		replay:         replay,
+		replayFile:     *replayFile,
+		replayPeer:     *replayPeer,
+		replayRealTime: *replayRealTime,
+		faults:         faults,
+		faultSpec:      *debugFaults,
+	}
+	if _, err := run(ctx, p, opts); err != nil {
+		fatal(log, "run failed", "err", err)
+	}
+	if sig, ok := interrupted.Load().(syscall.Signal); ok {
+		os.Exit(128 + int(sig))
+	}
+	os.Exit(0)
+}
+
+// printHeader names the target results are for when there are several
+func printHeader(targets int, job protocol.CrackingJob) {
+	fmt.Println("\n==== Cracking Results ====")
+	if targets > 1 {
+		fmt.Printf("Target %d:                 %s\n", job.Id, job.Username)
+	}
+}
+
+func printResults(targets int, r Result, parseTime time.Duration) {
+	printHeader(targets, r.Job)
+	switch {
+	case r.Restored:
+		fmt.Println("Password Found:", r.Password)
+		return
+	case r.Found:
+		fmt.Println("Password Found:", r.Password)
+	case r.Interrupted != nil:
+		fmt.Printf("Interrupted by %s before the password was found\n", r.Interrupted)
+	default:
+		fmt.Println("Password Not Found")
+	}
+
+	fmt.Println("\n==== Metrics ====")
+	fmt.Printf("Controller parse time:    %s\n", humanDuration(parseTime))
+	fmt.Printf("Job dispatch latency:     %s\n", humanDuration(r.Metrics.JobDispatch))
+	fmt.Printf("Worker cracking time:     %s\n", humanDuration(r.Metrics.WorkerCrack))
+	fmt.Printf("Result return latency:    %s\n", humanDuration(r.Metrics.ResultReturn))
+	fmt.Printf("End-to-end runtime:       %s\n", humanDuration(r.EndToEnd))
+	fmt.Printf("Session runtime:          %s\n", humanDuration(r.Elapsed))
+	fmt.Printf("Candidates tested:        %d\n", r.Tested)
+	fmt.Printf("Keyspace progress:        %s\n", r.Progress)
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metricsHandler serves the state of the session current returns, the one
+// for the target being cracked, in the Prometheus text format
+func metricsHandler(current func() *session) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		sess := current()
+		if sess == nil {
+			return
+		}
+		bw := bufio.NewWriter(w)
+		sess.writeMetrics(bw, time.Now())
+		bw.Flush()
+	})
+}
+
+// writeMetrics writes one sample per metric, and per worker for the worker
+// metrics, in the Prometheus text exposition format
+func (s *session) writeMetrics(w *bufio.Writer, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.workers))
+	for id := range s.workers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	job := fmt.Sprintf(`{job="%d",username="%s"}`, s.job.Id, escapeLabel(s.job.Username))
+
+	metric(w, "cracker_workers_connected", "gauge", "Workers currently connected to the controller.")
+	fmt.Fprintf(w, "cracker_workers_connected %d\n", len(s.conns))
+
+	metric(w, "cracker_worker_hashes_per_second", "gauge", "Hash rate reported in the worker's last heartbeat.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "cracker_worker_hashes_per_second{worker=\"%s\"} %g\n", escapeLabel(id), s.workers[id].rate)
+	}
+
+	metric(w, "cracker_worker_threads_active", "gauge", "Cracking threads busy with a job at the worker's last heartbeat.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "cracker_worker_threads_active{worker=\"%s\"} %d\n", escapeLabel(id), s.workers[id].threadsActive)
+	}
+
+	metric(w, "cracker_worker_candidates_tested_total", "counter", "Candidates tested by the worker during this run.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "cracker_worker_candidates_tested_total{worker=\"%s\"} %d\n", escapeLabel(id), s.workers[id].tested)
+	}
+
+	metric(w, "cracker_worker_thread_candidates_tested", "gauge", "Candidates each of the worker's threads tested on its current job.")
+	for _, id := range ids {
+		for thread, tested := range s.workers[id].threadTested {
+			fmt.Fprintf(w, "cracker_worker_thread_candidates_tested{worker=\"%s\",thread=\"%d\"} %d\n", escapeLabel(id), thread, tested)
+		}
+	}
+
+	metric(w, "cracker_worker_cpu_seconds_total", "counter", "CPU time the worker process has used, from its last heartbeat.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "cracker_worker_cpu_seconds_total{worker=\"%s\"} %g\n", escapeLabel(id), s.workers[id].cpuSeconds)
+	}
+
+	metric(w, "cracker_worker_resident_memory_bytes", "gauge", "Resident memory of the worker process, from its last heartbeat.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "cracker_worker_resident_memory_bytes{worker=\"%s\"} %d\n", escapeLabel(id), s.workers[id].rssBytes)
+	}
+
+	metric(w, "cracker_worker_seconds_since_heartbeat", "gauge", "Time since the worker's last heartbeat.")
+	for _, id := range ids {
+		if last := s.workers[id].lastHeartbeat; !last.IsZero() {
+			fmt.Fprintf(w, "cracker_worker_seconds_since_heartbeat{worker=\"%s\"} %g\n", escapeLabel(id), now.Sub(last).Seconds())
+		}
+	}
+
+	metric(w, "cracker_candidates_tested_total", "counter", "Candidates tested across all workers and restored runs.")
+	fmt.Fprintf(w, "cracker_candidates_tested_total %d\n", s.metrics.Tested)
+
+	metric(w, "cracker_heartbeats_total", "counter", "Heartbeat responses received from workers.")
+	fmt.Fprintf(w, "cracker_heartbeats_total %d\n", s.metrics.Heartbeats)
+
+	metric(w, "cracker_job_candidates_dispatched", "gauge", "Candidates of the job handed out to workers.")
+	fmt.Fprintf(w, "cracker_job_candidates_dispatched%s %d\n", job, s.next-s.job.Start)
+
+	metric(w, "cracker_job_candidates_completed", "gauge", "Candidates of the job known to be tested.")
+	fmt.Fprintf(w, "cracker_job_candidates_completed%s %d\n", job, s.coveredLocked())
+
+	progress := s.progressLocked()
+	if progress.HasPercent {
+		metric(w, "cracker_job_progress_ratio", "gauge", "Share of the job's keyspace tested, up to the current candidate length if it has no max length.")
+		fmt.Fprintf(w, "cracker_job_progress_ratio%s %g\n", job, progress.Percent/100)
+	}
+	if progress.HasETA {
+		metric(w, "cracker_job_eta_seconds", "gauge", "Estimated time left to search the job's keyspace, up to the current candidate length if it has no max length.")
+		fmt.Fprintf(w, "cracker_job_eta_seconds%s %g\n", job, progress.ETA.Seconds())
+	}
+
+	metric(w, "cracker_hashes_cracked_total", "counter", "Hashes whose password has been found.")
+	fmt.Fprintf(w, "cracker_hashes_cracked_total %d\n", len(s.results))
+}
+
+func metric(w *bufio.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Weight of a new sample in the smoothed overall rate
+const etaSmoothing = 0.3
+
+// progressEstimator turns the running count of tested candidates into a
+// percentage of a total and an ETA from a smoothed rate
+type progressEstimator struct {
+	rate       float64
+	lastTested int64
+	lastAt     time.Time
+}
+
+func newProgressEstimator(tested int64, now time.Time) *progressEstimator {
+	return &progressEstimator{lastTested: tested, lastAt: now}
+}
+
+// observe folds the rate since the previous observation into the estimate
+func (p *progressEstimator) observe(tested int64, now time.Time) {
+	elapsed := now.Sub(p.lastAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	rate := float64(tested-p.lastTested) / elapsed
+	if p.rate == 0 {
+		p.rate = rate
+	} else {
+		p.rate += etaSmoothing * (rate - p.rate)
+	}
+	p.lastTested = tested
+	p.lastAt = now
+}
+
+// percent of total tested, capped at 100 as reassigned ranges can be tested
+// twice
+func (p *progressEstimator) percent(tested int64, total int64) (float64, bool) {
+	if total <= 0 {
+		return 0, false
+	}
+	return min(100*float64(tested)/float64(total), 100), true
+}
+
+// eta is the time left to test the rest of total at rate, usually the
+// smoothed one. A wait too long for a time.Duration is capped at the longest
+// one.
+func (p *progressEstimator) eta(tested int64, total int64, rate float64) (time.Duration, bool) {
+	if total <= 0 || rate <= 0 {
+		return 0, false
+	}
+	left := float64(max(total-tested, 0)) / rate * float64(time.Second)
+	if left >= math.MaxInt64 {
+		return math.MaxInt64, true
+	}
+	return time.Duration(left), true
+}
+
+func (r Progress) String() string {
+	s := fmt.Sprintf("tested %d", r.Tested)
+	if r.HasPercent {
+		s += fmt.Sprintf(" of %d (%.2f%%)", r.Total, r.Percent)
+		if r.Length > 0 {
+			s += fmt.Sprintf(" up to length %d", r.Length)
+		}
+	}
+	s += fmt.Sprintf(" | rate %.2f/sec", r.Rate)
+	if r.HasETA {
+		s += fmt.Sprintf(" | eta %s", r.ETA.Round(time.Second))
+	} else {
+		s += " | eta unknown"
+	}
+	return s
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+func TestETAOfHugeKeyspace(t *testing.T) {
+	p := newProgressEstimator(0, time.Now())
+
+	// The default charset up to length 12 at a slow rate
+	_, end := keyspace.Bounds(len(keyspace.DefaultCharset), 1, 12)
+	eta, ok := p.eta(0, end, 10)
+	if !ok || eta != math.MaxInt64 {
+		t.Fatalf("eta %v, %v, want it capped at %v", eta, ok, time.Duration(math.MaxInt64))
+	}
+
+	if eta, ok := p.eta(50, 100, 10); !ok || eta != 5*time.Second {
+		t.Fatalf("eta %v, %v, want 5s", eta, ok)
+	}
+}
+
+func TestProgressOfUnboundedJob(t *testing.T) {
+	job := protocol.CrackingJob{Id: 1, Setting: "$1$salt", FullHash: "$1$salt$hash", Charset: "AB"}
+	job.Start, _ = keyspace.Bounds(len(job.Charset), 2, 0)
+	sess := newSession(job, 2*time.Second)
+
+	// Nothing handed out yet, the total runs to the end of length 2
+	p := sess.updateProgress()
+	if !p.HasPercent || p.Total != 4 || p.Length != 2 {
+		t.Fatalf("progress %+v, want a total of 4 up to length 2", p)
+	}
+
+	// The first 100 candidates run from AA into length 6, which ends 124
+	// candidates after AA
+	if _, err := sess.assign(&protocol.WorkerHello{WorkerId: "w", Threads: 1}, make(chan protocol.Message, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if p := sess.updateProgress(); p.Total != 124 || p.Length != 6 {
+		t.Fatalf("progress %+v, want a total of 124 up to length 6", p)
+	}
+}
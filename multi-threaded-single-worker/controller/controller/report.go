@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cracker/protocol"
+)
+
+// rateSample is one worker heartbeat as the run report lists it
+type rateSample struct {
+	At       time.Time     `json:"at"`
+	WorkerId string        `json:"worker_id"`
+	Rate     float64       `json:"rate"`
+	Tested   int64         `json:"tested"`
+	Window   time.Duration `json:"window_ns"`
+}
+
+// runReport is everything -report writes out about a finished run, so runs
+// and algorithms can be compared by scripts rather than by reading the
+// metrics block
+type runReport struct {
+	Job     reportJob      `json:"job"`
+	Result  reportResult   `json:"result"`
+	Metrics reportMetrics  `json:"metrics"`
+	Workers []reportWorker `json:"workers"`
+	Samples []rateSample   `json:"rate_samples"`
+}
+
+type reportJob struct {
+	Id        int    `json:"id"`
+	Username  string `json:"username"`
+	Algorithm string `json:"algorithm"`
+	Attack    string `json:"attack"`
+	Wordlist  string `json:"wordlist,omitempty"`
+	Setting   string `json:"setting"`
+	FullHash  string `json:"full_hash"`
+	Charset   string `json:"charset"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Interval  int    `json:"interval"`
+}
+
+type reportResult struct {
+	Found       bool   `json:"found"`
+	Password    string `json:"password,omitempty"`
+	Interrupted string `json:"interrupted,omitempty"`
+}
+
+type reportMetrics struct {
+	ParseTime      time.Duration `json:"parse_time_ns"`
+	JobDispatch    time.Duration `json:"job_dispatch_ns"`
+	WorkerCrack    time.Duration `json:"worker_crack_ns"`
+	ResultReturn   time.Duration `json:"result_return_ns"`
+	EndToEnd       time.Duration `json:"end_to_end_ns"`
+	SessionRuntime time.Duration `json:"session_runtime_ns"`
+	Tested         int64         `json:"tested"`
+	Heartbeats     int64         `json:"heartbeats"`
+	Percent        float64       `json:"percent,omitempty"`
+}
+
+type reportWorker struct {
+	WorkerId   string  `json:"worker_id"`
+	Threads    int     `json:"threads"`
+	Tested     int64   `json:"tested"`
+	LastRate   float64 `json:"last_rate"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+	RSSBytes   int64   `json:"rss_bytes"`
+}
+
+// buildReport gathers the report for a run that ended with result
+func buildReport(sess *session, result ResultMsg, parseTime, endToEnd time.Duration) *runReport {
+	v := sess.view()
+	totals := sess.snapshot().Metrics
+
+	var interrupted string
+	if result.Interrupted != nil {
+		interrupted = result.Interrupted.Error()
+	}
+	r := &runReport{
+		Job: reportJob{
+			Id:        v.Job.Id,
+			Username:  v.Job.Username,
+			Algorithm: protocol.Algorithm(v.Job.Setting),
+			Attack:    attackOf(v.Job),
+			Wordlist:  v.Job.Wordlist,
+			Setting:   v.Job.Setting,
+			FullHash:  v.Job.FullHash,
+			Charset:   v.Job.Charset,
+			Start:     v.Job.Start,
+			End:       v.Job.End,
+			Interval:  v.Job.Interval,
+		},
+		Result: reportResult{
+			Found:       result.Password != "",
+			Password:    result.Password,
+			Interrupted: interrupted,
+		},
+		Metrics: reportMetrics{
+			ParseTime:      parseTime,
+			EndToEnd:       endToEnd,
+			SessionRuntime: totals.Elapsed,
+			Tested:         v.Progress.Tested,
+			Heartbeats:     totals.Heartbeats,
+			Percent:        v.Progress.Percent,
+		},
+		Samples: sess.rateSamples(),
+	}
+	if result.Metrics != nil {
+		r.Metrics.JobDispatch = result.Metrics.JobDispatch
+		r.Metrics.WorkerCrack = result.Metrics.WorkerCrack
+		r.Metrics.ResultReturn = result.Metrics.ResultReturn
+	}
+	for _, w := range v.Workers {
+		r.Workers = append(r.Workers, reportWorker{
+			WorkerId:   w.WorkerId,
+			Threads:    w.Threads,
+			Tested:     w.Tested,
+			LastRate:   w.Rate,
+			CPUSeconds: w.CPUSeconds,
+			RSSBytes:   w.RSSBytes,
+		})
+	}
+	return r
+}
+
+// writeReport saves r as CSV if path ends in .csv and as JSON otherwise
+func writeReport(path string, r *runReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = r.writeCSV(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(r)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("write report: %w", err)
+	}
+	return f.Close()
+}
+
+// writeCSV flattens the report into one row per value, so every section fits
+// the same columns and can be filtered on kind
+func (r *runReport) writeCSV(f *os.File) error {
+	w := csv.NewWriter(f)
+	row := func(kind, workerId string, at time.Time, name string, value any) {
+		var when string
+		if !at.IsZero() {
+			when = at.Format(time.RFC3339Nano)
+		}
+		w.Write([]string{kind, workerId, when, name, fmt.Sprint(value)})
+	}
+	nanos := func(d time.Duration) string { return strconv.FormatInt(d.Nanoseconds(), 10) }
+
+	w.Write([]string{"kind", "worker_id", "time", "name", "value"})
+
+	row("job", "", time.Time{}, "id", r.Job.Id)
+	row("job", "", time.Time{}, "username", r.Job.Username)
+	row("job", "", time.Time{}, "algorithm", r.Job.Algorithm)
+	row("job", "", time.Time{}, "attack", r.Job.Attack)
+	if r.Job.Wordlist != "" {
+		row("job", "", time.Time{}, "wordlist", r.Job.Wordlist)
+	}
+	row("job", "", time.Time{}, "setting", r.Job.Setting)
+	row("job", "", time.Time{}, "full_hash", r.Job.FullHash)
+	row("job", "", time.Time{}, "charset", r.Job.Charset)
+	row("job", "", time.Time{}, "start", r.Job.Start)
+	row("job", "", time.Time{}, "end", r.Job.End)
+	row("job", "", time.Time{}, "interval", r.Job.Interval)
+
+	row("result", "", time.Time{}, "found", r.Result.Found)
+	row("result", "", time.Time{}, "password", r.Result.Password)
+	row("result", "", time.Time{}, "interrupted", r.Result.Interrupted)
+
+	row("metric", "", time.Time{}, "parse_time_ns", nanos(r.Metrics.ParseTime))
+	row("metric", "", time.Time{}, "job_dispatch_ns", nanos(r.Metrics.JobDispatch))
+	row("metric", "", time.Time{}, "worker_crack_ns", nanos(r.Metrics.WorkerCrack))
+	row("metric", "", time.Time{}, "result_return_ns", nanos(r.Metrics.ResultReturn))
+	row("metric", "", time.Time{}, "end_to_end_ns", nanos(r.Metrics.EndToEnd))
+	row("metric", "", time.Time{}, "session_runtime_ns", nanos(r.Metrics.SessionRuntime))
+	row("metric", "", time.Time{}, "tested", r.Metrics.Tested)
+	row("metric", "", time.Time{}, "heartbeats", r.Metrics.Heartbeats)
+	row("metric", "", time.Time{}, "percent", r.Metrics.Percent)
+
+	for _, wk := range r.Workers {
+		row("worker", wk.WorkerId, time.Time{}, "threads", wk.Threads)
+		row("worker", wk.WorkerId, time.Time{}, "tested", wk.Tested)
+		row("worker", wk.WorkerId, time.Time{}, "last_rate", wk.LastRate)
+		row("worker", wk.WorkerId, time.Time{}, "cpu_seconds", wk.CPUSeconds)
+		row("worker", wk.WorkerId, time.Time{}, "rss_bytes", wk.RSSBytes)
+	}
+
+	for _, s := range r.Samples {
+		row("sample", s.WorkerId, s.At, "rate", s.Rate)
+		row("sample", s.WorkerId, s.At, "tested", s.Tested)
+		row("sample", s.WorkerId, s.At, "window_ns", nanos(s.Window))
+	}
+
+	w.Flush()
+	return w.Error()
+}
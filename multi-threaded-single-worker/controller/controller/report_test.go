@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteReport(t *testing.T) {
+	r := &runReport{
+		Job:     reportJob{Id: 1, Username: "aryan", Algorithm: "md5", Attack: AttackWordlist, Wordlist: "words.txt", End: 42},
+		Result:  reportResult{Interrupted: "interrupt"},
+		Metrics: reportMetrics{EndToEnd: time.Second, Tested: 21, Percent: 50},
+		Workers: []reportWorker{{WorkerId: "w1", Threads: 2, Tested: 21}},
+		Samples: []rateSample{{At: time.Unix(1, 0), WorkerId: "w1", Rate: 10, Tested: 21, Window: time.Second}},
+	}
+	dir := t.TempDir()
+
+	t.Run("csv", func(t *testing.T) {
+		path := filepath.Join(dir, "run.csv")
+		if err := writeReport(path, r); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		rows, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := make(map[string]string)
+		for _, row := range rows[1:] {
+			values[row[0]+"."+row[1]+"."+row[3]] = row[4]
+		}
+		want := map[string]string{
+			"job..attack":           "wordlist",
+			"job..wordlist":         "words.txt",
+			"result..found":         "false",
+			"result..interrupted":   "interrupt",
+			"metric..end_to_end_ns": "1000000000",
+			"worker.w1.tested":      "21",
+			"sample.w1.rate":        "10",
+		}
+		for key, value := range want {
+			if got, ok := values[key]; !ok || got != value {
+				t.Errorf("%s is %q, want %q", key, got, value)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "run.json")
+		if err := writeReport(path, r); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got runReport
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Job != r.Job || got.Result != r.Result || got.Metrics != r.Metrics || len(got.Workers) != 1 || len(got.Samples) != 1 {
+			t.Fatalf("read back %+v, want %+v", got, *r)
+		}
+	})
+}
@@ -0,0 +1,441 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cracker/chaos"
+	"cracker/logging"
+	"cracker/protocol"
+	"cracker/record"
+)
+
+// Events lets a program running the controller follow along. Either may be
+// nil. They are called from the run's own goroutines and should return
+// quickly.
+type Events struct {
+	// Progress is called every heartbeat interval while job is cracked
+	Progress func(job protocol.CrackingJob, p Progress)
+	// Result is called as each target is over
+	Result func(r Result)
+}
+
+// Result is how one target's run ended. A Restored result was found by an
+// earlier run and read back from the session file, with nothing run for it.
+type Result struct {
+	Job      protocol.CrackingJob
+	Password string
+	Found    bool
+	Restored bool
+	// Interrupted is why the run was cancelled before the target was over
+	Interrupted error
+	// Metrics times the chunk that found the password
+	Metrics  Metrics
+	Progress Progress
+	Tested   int64
+	// Elapsed adds up every run of the session, EndToEnd is this one's
+	Elapsed  time.Duration
+	EndToEnd time.Duration
+}
+
+// targetRun is the session being cracked and where its result arrives
+type targetRun struct {
+	sess     *session
+	resultCh chan ResultMsg
+}
+
+// runOptions are the command line's debugging aids, which Run leaves out
+type runOptions struct {
+	start          time.Time
+	parseTime      time.Duration
+	logOutput      *logging.Output
+	replay         []record.Entry
+	replayFile     string
+	replayPeer     string
+	replayRealTime bool
+	faults         chaos.Faults
+	faultSpec      string
+}
+
+// Run cracks cfg's targets one after another, serving each to the workers
+// that connect on cfg.Listen, and returns how each ended. Sessions,
+// checkpoints and reports are written as cfg says, the same as the command
+// line does. Cancelling ctx shuts the workers down, saves the session and
+// returns what was done so far.
+func Run(ctx context.Context, cfg Config) ([]Result, error) {
+	start := time.Now()
+	p, err := check(cfg, newConfigSource(), false, "")
+	if err != nil {
+		return nil, err
+	}
+	return run(ctx, p, runOptions{start: start, parseTime: time.Since(start)})
+}
+
+// run serves the checked plan p
+func run(ctx context.Context, p *plan, opts runOptions) ([]Result, error) {
+	cfg := p.cfg
+	var wg sync.WaitGroup
+
+	log := cfg.Logger
+	logOutput := opts.logOutput
+	if log == nil {
+		logOutput = logging.NewOutput(os.Stdout)
+		var err error
+		if log, err = logging.New(logOutput, cfg.Log, "controller"); err != nil {
+			return nil, fmt.Errorf("set up logging: %w", err)
+		}
+	}
+
+	var rec *record.Recorder
+	if cfg.Output.Record != "" {
+		var err error
+		rec, err = record.Create(cfg.Output.Record, record.Controller)
+		if err != nil {
+			return nil, fmt.Errorf("start recording: %w", err)
+		}
+	}
+	defer rec.Close()
+
+	var current atomic.Pointer[targetRun]
+	currentSession := func() *session {
+		if run := current.Load(); run != nil {
+			return run.sess
+		}
+		return nil
+	}
+
+	// The pprof handlers are on the default mux, metrics join them on a mux
+	// of the run's own so a second run does not register them twice
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(currentSession))
+		mux.Handle("/debug/pprof/", http.DefaultServeMux)
+		server := &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+		defer server.Close()
+		go func() {
+			log.Info("serving metrics", "url", fmt.Sprintf("http://%s/metrics", cfg.MetricsAddr))
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("metrics server failed", "err", err)
+			}
+		}()
+	}
+
+	// Workers may drop and reconnect at any time, keep accepting until the
+	// last target is over. Each connection joins whichever target is being
+	// cracked when it arrives. A replay has no network.
+	var ln net.Listener
+	over := make(chan struct{})
+	if opts.replay == nil {
+		address := fmt.Sprintf(":%d", cfg.Listen.Port)
+		var err error
+		if p.tls != nil {
+			ln, err = tls.Listen("tcp", address, p.tls)
+		} else {
+			ln, err = net.Listen("tcp", address)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listen on %s: %w", address, err)
+		}
+		defer ln.Close()
+		log.Info("listening for workers", "address", address, "tls", p.tls != nil, "targets", len(p.targets))
+		if !opts.faults.Zero() {
+			log.Warn("injecting faults into worker connections", "faults", opts.faultSpec)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					select {
+					case <-over:
+					default:
+						log.Error("accept error", "err", err)
+					}
+					return
+				}
+				run := current.Load()
+				if run == nil {
+					// Not serving a target yet, the worker will be back
+					conn.Close()
+					continue
+				}
+				log.Info("worker connected", "remote", conn.RemoteAddr().String())
+				if !opts.faults.Zero() {
+					conn = chaos.Wrap(conn, opts.faults)
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					handleWorkerConnection(conn, run.sess, cfg.heartbeatSeconds(), run.resultCh, rec, log.With("job_id", run.sess.job.Id))
+				}()
+			}
+		}()
+	}
+
+	var results []Result
+	var last *session
+	var failed error
+	for i, t := range p.targets {
+		targetStart := time.Now()
+		if i == 0 {
+			targetStart = opts.start
+		}
+
+		var sess *session
+		if t.restore != nil {
+			sess = restoreSession(t.restore, cfg.ChunkDuration)
+		} else {
+			sess = newSession(t.job, cfg.ChunkDuration)
+		}
+		sess.job.Interval = cfg.heartbeatSeconds()
+		sess.words = t.words
+		log := log.With("job_id", sess.job.Id)
+		if t.restore != nil {
+			log.Info("session restored",
+				"file", t.session,
+				"saved_at", t.restore.SavedAt.Format(time.RFC3339),
+				"pending_ranges", len(t.restore.Pending),
+				"tested", t.restore.Metrics.Tested,
+			)
+		}
+
+		if found, ok := sess.result(); ok {
+			r := Result{Job: sess.job, Password: found.Password, Found: true, Restored: true}
+			results = append(results, r)
+			if cfg.Events.Result != nil {
+				cfg.Events.Result(r)
+			}
+			continue
+		}
+
+		// Workers of the previous target are dropped once this one is in
+		// place, and join it when they reconnect
+		run := &targetRun{sess: sess, resultCh: make(chan ResultMsg)}
+		current.Store(run)
+		if last != nil {
+			last.release()
+		}
+		last = sess
+
+		result, err := runTarget(ctx, run, t, cfg, log, logOutput, rec, opts, &wg)
+		if err != nil {
+			failed = fmt.Errorf("target %d: %w", sess.job.Id, err)
+			break
+		}
+		endToEnd := time.Since(targetStart)
+
+		totals := sess.snapshot().Metrics
+		r := Result{
+			Job:         sess.job,
+			Password:    result.Password,
+			Found:       result.Password != "",
+			Interrupted: result.Interrupted,
+			Progress:    sess.updateProgress(),
+			Tested:      totals.Tested,
+			Elapsed:     totals.Elapsed,
+			EndToEnd:    endToEnd,
+		}
+		if result.Metrics != nil {
+			r.Metrics = *result.Metrics
+		}
+		results = append(results, r)
+		if cfg.Events.Result != nil {
+			cfg.Events.Result(r)
+		}
+
+		if t.report != "" {
+			if err := writeReport(t.report, buildReport(sess, result, opts.parseTime, endToEnd)); err != nil {
+				log.Error("report failed", "err", err)
+			} else {
+				log.Info("report written", "file", t.report)
+			}
+		}
+		if result.Interrupted != nil {
+			break
+		}
+	}
+
+	if last != nil {
+		switch {
+		case failed != nil || ctx.Err() != nil:
+			// The workers were told to shut down, drop any that did not
+			last.release()
+		case cfg.KeepWorkers:
+			log.Info("releasing workers")
+			last.release()
+		default:
+			log.Info("sending shutdown")
+			last.broadcast(protocol.Message{Command: protocol.MsgShutdown})
+		}
+	}
+	close(over)
+	if ln != nil {
+		ln.Close()
+	}
+
+	wg.Wait()
+	return results, failed
+}
+
+// heartbeatSeconds is the heartbeat interval as jobs carry it
+func (cfg Config) heartbeatSeconds() int {
+	return int(cfg.Heartbeat / time.Second)
+}
+
+// runTarget cracks one target, returning once its password is found, its
+// keyspace runs out or ctx is cancelled
+func runTarget(ctx context.Context, run *targetRun, t target, cfg Config, log *slog.Logger, logOutput *logging.Output, rec *record.Recorder, opts runOptions, wg *sync.WaitGroup) (ResultMsg, error) {
+	sess, resultCh := run.sess, run.resultCh
+	job := sess.job
+	log.Info("job created",
+		"interval", job.Interval,
+		"username", job.Username,
+		"settings", job.Setting,
+		"full_hash", job.FullHash,
+		"attack", attackOf(job),
+		"keyspace_start", job.Start,
+		"keyspace_size", keyspaceSize(job),
+	)
+
+	// A replay leaves the session file alone
+	saveSession := func() {
+		if opts.replay != nil {
+			return
+		}
+		if err := saveCheckpoint(t.session, sess.snapshot()); err != nil {
+			log.Error("checkpoint failed", "err", err)
+		}
+	}
+
+	var dash *dashboard
+	if cfg.UI {
+		switch {
+		case logOutput == nil:
+			log.Warn("the dashboard needs the log to itself, logging instead as a Logger was given")
+		case !isTerminal(os.Stdout):
+			log.Warn("stdout is not a terminal, logging instead of showing the dashboard")
+		default:
+			dash = startDashboard(os.Stdout, sess, cfg.Heartbeat, logOutput)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sess.done:
+				return
+			case <-ticker.C:
+				p := sess.updateProgress()
+				args := []any{"tested", p.Tested, "rate", p.Rate}
+				if p.HasPercent {
+					args = append(args, "total", p.Total, "percent", p.Percent)
+				}
+				if p.HasETA {
+					args = append(args, "eta", p.ETA.Round(time.Second))
+				}
+				log.Info("progress", args...)
+				if cfg.Events.Progress != nil {
+					cfg.Events.Progress(job, p)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(cfg.Checkpoint.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sess.done:
+				return
+			case <-ticker.C:
+				saveSession()
+			}
+		}
+	}()
+
+	if opts.replay != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			controllerEnd, workerEnd := net.Pipe()
+
+			handled := make(chan struct{})
+			go func() {
+				defer close(handled)
+				handleWorkerConnection(controllerEnd, sess, job.Interval, resultCh, rec, log)
+			}()
+
+			wait := 2 * cfg.Heartbeat
+			summary, err := record.Replay(workerEnd, opts.replay, record.Controller, opts.replayPeer, wait, opts.replayRealTime, log.With("replay", opts.replayFile))
+			<-handled
+			log.Info("replay finished", "sent", summary.Sent, "received", summary.Received, "diverged", summary.Diverged)
+
+			if err == nil {
+				err = fmt.Errorf("replay ended without a result")
+			}
+			select {
+			case resultCh <- ResultMsg{Err: err}:
+			case <-sess.done:
+			}
+		}()
+	}
+
+	var result ResultMsg
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		result = ResultMsg{Metrics: &Metrics{}, Interrupted: context.Cause(ctx)}
+		log.Warn("run cancelled, shutting down workers", "cause", result.Interrupted)
+
+		sess.stopWorkers()
+		if !sess.awaitCancelled(2 * cfg.Heartbeat) {
+			log.Warn("some workers did not send a final heartbeat")
+		}
+	}
+	sess.finish()
+	if dash != nil {
+		dash.close()
+	}
+
+	if result.Err != nil {
+		saveSession()
+		log.Error("job failed", "err", result.Err)
+		return result, result.Err
+	}
+
+	if result.Password != "" {
+		sess.cracked(result.Password)
+
+		// Give the workers told to cancel a moment to report how far they got
+		if !sess.awaitCancelled(2 * cfg.Heartbeat) {
+			log.Warn("some workers did not confirm the cancel")
+		}
+	}
+	saveSession()
+	return result, nil
+}
+
+func attackOf(job protocol.CrackingJob) string {
+	if job.Wordlist != "" {
+		return AttackWordlist
+	}
+	return AttackBruteForce
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"cracker/protocol"
+	"cracker/worker"
+)
+
+// libraryConfig is a controller config for one sample shadow file, on a free
+// port, logging nowhere
+func libraryConfig(t *testing.T, shadow string, maxLength int) Config {
+	t.Helper()
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg := DefaultConfig()
+	cfg.Listen.Port = port
+	cfg.Heartbeat = time.Second
+	cfg.ChunkDuration = time.Second
+	cfg.Checkpoint.Path = filepath.Join(t.TempDir(), "run.session")
+	cfg.Targets = []TargetConfig{{
+		Shadow:    filepath.Join("..", "..", "passwords", shadow),
+		User:      "aryan",
+		Charset:   sampleCharset,
+		MaxLength: maxLength,
+	}}
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return cfg
+}
+
+// startWorker runs a worker against cfg's controller until ctx ends or the
+// controller shuts it down, and returns what Run returned on the channel
+func startWorker(ctx context.Context, cfg Config, events worker.Events) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Run(ctx, worker.Config{
+			Address:  net.JoinHostPort("localhost", strconv.Itoa(cfg.Listen.Port)),
+			WorkerId: "library",
+			Threads:  2,
+			Logger:   cfg.Logger,
+			Events:   events,
+		})
+	}()
+	return done
+}
+
+func TestRunLibrary(t *testing.T) {
+	cfg := libraryConfig(t, "shadow_EAR_md5", 3)
+	var events []Result
+	cfg.Events.Result = func(r Result) { events = append(events, r) }
+
+	found := make(chan worker.Result, 1)
+	workerDone := startWorker(context.Background(), cfg, worker.Events{
+		Result: func(r worker.Result) {
+			if r.Found {
+				found <- r
+			}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), harnessTimeout)
+	defer cancel()
+	results, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Found || results[0].Password != "EAR" {
+		t.Fatalf("got %+v, want EAR found", results)
+	}
+	if len(events) != 1 || events[0].Password != "EAR" {
+		t.Fatalf("Result event got %+v", events)
+	}
+
+	// The controller shuts its workers down once the last target is over
+	select {
+	case err := <-workerDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("worker still running after the controller finished")
+	}
+	select {
+	case r := <-found:
+		if r.Password != "EAR" || r.Job.Username != "aryan" {
+			t.Fatalf("worker reported %+v", r)
+		}
+	default:
+		t.Fatal("worker never reported finding the password")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	// None of the password's letters, so the run only ends when cancelled
+	cfg := libraryConfig(t, "shadow_ACE_yescrypt", 10)
+	cfg.Targets[0].Charset = "BD"
+	progress := make(chan Progress, 16)
+	cfg.Events.Progress = func(job protocol.CrackingJob, p Progress) {
+		select {
+		case progress <- p:
+		default:
+		}
+	}
+
+	heartbeats := make(chan protocol.HeartbeatResponse, 16)
+	workerDone := startWorker(context.Background(), cfg, worker.Events{
+		Progress: func(hb protocol.HeartbeatResponse) {
+			select {
+			case heartbeats <- hb:
+			default:
+			}
+		},
+	})
+
+	stop := errors.New("stopped by the test")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		// Stop once the controller has seen the worker make progress
+		for p := range progress {
+			if p.Tested > 0 {
+				cancel(stop)
+				return
+			}
+		}
+	}()
+
+	results, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Found || !errors.Is(results[0].Interrupted, stop) {
+		t.Fatalf("got %+v, want the run interrupted by the test", results)
+	}
+	if results[0].Tested == 0 {
+		t.Fatal("no candidates counted before the interrupt")
+	}
+	if len(heartbeats) == 0 {
+		t.Fatal("worker reported no heartbeats")
+	}
+
+	select {
+	case err := <-workerDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("worker still running after the controller was cancelled")
+	}
+}
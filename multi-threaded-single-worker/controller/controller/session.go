@@ -0,0 +1,738 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+var errNoWork = errors.New("no work available")
+
+const (
+	// Chunk size for a worker whose rate has not been measured yet
+	initialChunkPerThread = 100
+	minChunk              = 16
+
+	// Weight of a new rate sample in a worker's smoothed rate
+	rateSmoothing = 0.3
+
+	// Heartbeat samples kept for the run report, enough for a day of one
+	// worker beating every second
+	maxRateSamples = 100000
+)
+
+// assignment is a chunk of the job's keyspace owned by one worker
+type assignment struct {
+	workerId      string
+	start         int64
+	end           int64
+	lastCompleted int64
+
+	// Where the worker was told to start last time, to measure its rate
+	sentStart int64
+}
+
+// workerStats is what the controller last heard from a worker
+type workerStats struct {
+	threads       int
+	threadsActive int64
+	threadTested  []int64
+	rate          float64
+	tested        int64
+	lastTotal     int64
+	cpuSeconds    float64
+	cpuPercent    float64
+	rssBytes      int64
+	lastHeartbeat time.Time
+}
+
+// session tracks the job being cracked and hands its keyspace out in chunks,
+// each sized from the worker's measured rate to take about chunkTarget. A
+// worker that reconnects is handed back the rest of its own chunk instead of
+// starting over.
+type session struct {
+	mu          sync.Mutex
+	job         protocol.CrackingJob
+	chunkTarget time.Duration
+	next        int64
+	assignments map[string]*assignment
+	conns       map[string]chan<- protocol.Message
+	workers     map[string]*workerStats
+	rates       map[string]float64
+	estimate    *progressEstimator
+	done        chan struct{}
+	released    chan struct{}
+	solved      bool
+
+	// Workers told to cancel or shut down that have not reported back yet,
+	// cancelled is closed once the set drains
+	cancelling map[string]struct{}
+	cancelled  chan struct{}
+
+	// Heartbeat rates for the run report, up to maxRateSamples
+	samples []rateSample
+
+	// Lines of the job's wordlist, nil for a brute-force job
+	words []string
+
+	// State carried over from a restored checkpoint
+	completed []keyRange
+	results   []crackedHash
+	metrics   sessionMetrics
+	started   time.Time
+}
+
+func newSession(job protocol.CrackingJob, chunkTarget time.Duration) *session {
+	return &session{
+		job:         job,
+		chunkTarget: chunkTarget,
+		next:        job.Start,
+		assignments: make(map[string]*assignment),
+		conns:       make(map[string]chan<- protocol.Message),
+		workers:     make(map[string]*workerStats),
+		estimate:    newProgressEstimator(0, time.Now()),
+		rates:       make(map[string]float64),
+		done:        make(chan struct{}),
+		released:    make(chan struct{}),
+		cancelling:  make(map[string]struct{}),
+		started:     time.Now(),
+	}
+}
+
+// restoreSession rebuilds a session from a checkpoint. Pending chunks become
+// assignments without a connection, to be resumed by the worker that held
+// them or taken over by any other.
+func restoreSession(cp *checkpoint, chunkTarget time.Duration) *session {
+	s := newSession(cp.Jobs[0], chunkTarget)
+	s.next = cp.Next
+	s.completed = cp.Completed
+	s.results = cp.Results
+	s.metrics = cp.Metrics
+	s.estimate = newProgressEstimator(s.metrics.Tested, time.Now())
+
+	for i, r := range cp.Pending {
+		// The tail that was never handed out is covered by next
+		if r.JobId != s.job.Id || r.Start >= cp.Next {
+			continue
+		}
+		workerId := r.WorkerId
+		if workerId == "" {
+			workerId = fmt.Sprintf("restored-%d", i)
+		}
+		s.assignments[workerId] = &assignment{
+			workerId:      workerId,
+			start:         r.Start,
+			end:           r.End,
+			lastCompleted: r.Start - 1,
+		}
+	}
+	return s
+}
+
+// assign returns the job a connected worker should run and registers it.
+// A worker that already holds a chunk resumes after the last candidate either
+// side has seen it complete; otherwise it takes over a chunk whose worker is
+// not connected, or gets a fresh one.
+func (s *session) assign(hello *protocol.WorkerHello, writeCh chan<- protocol.Message) (*protocol.CrackingJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.assignLocked(hello, writeCh)
+}
+
+// assignLocked is assign for callers that hold s.mu
+func (s *session) assignLocked(hello *protocol.WorkerHello, writeCh chan<- protocol.Message) (*protocol.CrackingJob, error) {
+	// Until the worker's own chunks and heartbeats say otherwise, size its
+	// chunks by the rate it benchmarked for the job's algorithm
+	if _, ok := s.rates[hello.WorkerId]; !ok {
+		if rate := hello.Bench[protocol.Algorithm(s.job.Setting)]; rate > 0 {
+			s.rates[hello.WorkerId] = rate
+		}
+	}
+	if s.solved {
+		return nil, errNoWork
+	}
+
+	a, ok := s.assignments[hello.WorkerId]
+	if r := hello.Resume; ok && r != nil && r.JobId == s.job.Id {
+		a.lastCompleted = max(a.lastCompleted, min(r.LastCompleted, a.end-1))
+
+		// The worker finished its chunk just before the connection dropped
+		if a.lastCompleted+1 >= a.end {
+			s.addCompleted(keyRange{JobId: s.job.Id, Start: a.start, End: a.end})
+			delete(s.assignments, hello.WorkerId)
+			ok = false
+		}
+	}
+
+	if !ok {
+		a = s.orphan()
+		if a != nil {
+			delete(s.assignments, a.workerId)
+		} else if a = s.nextChunk(hello.WorkerId, hello.Threads); a == nil {
+			return nil, errNoWork
+		}
+		a.workerId = hello.WorkerId
+		s.assignments[a.workerId] = a
+	}
+
+	// Only a worker that got work is registered, a rejected one hangs up
+	s.conns[hello.WorkerId] = writeCh
+	s.worker(hello.WorkerId).threads = hello.Threads
+	return s.jobLocked(a), nil
+}
+
+// jobLocked is the job that sends a's chunk from after its last completed
+// candidate. Callers must hold s.mu.
+func (s *session) jobLocked(a *assignment) *protocol.CrackingJob {
+	a.sentStart = a.lastCompleted + 1
+
+	job := s.job
+	job.Start = a.sentStart
+	job.End = a.end
+	if s.words != nil {
+		job.Words = s.words[job.Start:job.End]
+	}
+	return &job
+}
+
+// orphan returns an assignment whose worker is not connected. Callers must
+// hold s.mu.
+func (s *session) orphan() *assignment {
+	for _, a := range s.assignments {
+		if _, ok := s.conns[a.workerId]; !ok {
+			return a
+		}
+	}
+	return nil
+}
+
+// nextChunk carves a chunk for workerId, running threads threads, off the
+// unassigned keyspace, or returns nil once all of it has been handed out.
+// Callers must hold s.mu.
+func (s *session) nextChunk(workerId string, threads int) *assignment {
+	if s.job.End != 0 && s.next >= s.job.End {
+		return nil
+	}
+
+	end := s.next + s.chunkSize(workerId, threads)
+	if end < s.next {
+		// A wildly overstated rate must not wrap the index around
+		end = math.MaxInt64
+	}
+	if s.job.End != 0 {
+		end = min(end, s.job.End)
+	}
+
+	a := &assignment{start: s.next, end: end, lastCompleted: s.next - 1}
+	s.next = end
+	return a
+}
+
+// chunkSize is how many candidates workerId tests in about chunkTarget.
+// Without a measured rate it goes by the worker's thread count. Callers must
+// hold s.mu.
+func (s *session) chunkSize(workerId string, threads int) int64 {
+	rate, ok := s.rates[workerId]
+	if !ok {
+		return initialChunkPerThread * int64(max(threads, 1))
+	}
+	return max(int64(rate*s.chunkTarget.Seconds()), minChunk)
+}
+
+// worker returns the stats kept for workerId. Callers must hold s.mu.
+func (s *session) worker(workerId string) *workerStats {
+	w, ok := s.workers[workerId]
+	if !ok {
+		w = &workerStats{}
+		s.workers[workerId] = w
+	}
+	return w
+}
+
+// observeRate folds a rate sample into the worker's smoothed rate. Callers
+// must hold s.mu.
+func (s *session) observeRate(workerId string, rate float64) {
+	if rate <= 0 {
+		return
+	}
+	if old, ok := s.rates[workerId]; ok {
+		rate = old + rateSmoothing*(rate-old)
+	}
+	s.rates[workerId] = rate
+}
+
+// chunkDone retires the worker's chunk after it was searched without a match
+// and returns true once the whole keyspace has been searched
+func (s *session) chunkDone(workerId string, crackTime time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.assignments[workerId]; ok {
+		if crackTime > 0 {
+			s.observeRate(workerId, float64(a.end-a.sentStart)/crackTime.Seconds())
+		}
+		s.addCompleted(keyRange{JobId: s.job.Id, Start: a.start, End: a.end})
+		delete(s.assignments, workerId)
+	}
+
+	return s.job.End != 0 && s.next >= s.job.End && len(s.assignments) == 0
+}
+
+// addCompleted appends r, merging it into the previous range when they touch.
+// Callers must hold s.mu.
+func (s *session) addCompleted(r keyRange) {
+	if n := len(s.completed); n > 0 {
+		last := &s.completed[n-1]
+		if last.JobId == r.JobId && last.End == r.Start {
+			last.End = r.End
+			return
+		}
+	}
+	s.completed = append(s.completed, r)
+}
+
+// progress records a heartbeat: the candidates the worker tested since the
+// last one, its rate and the last candidate it completed in its current chunk
+func (s *session) progress(workerId string, hb *protocol.HeartbeatResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metrics.Heartbeats++
+	s.observeRate(workerId, hb.CurrentRate)
+
+	// Count from the running total so a lost delta is made up next time. The
+	// total runs on across jobs and only starts over when the worker restarts.
+	w := s.worker(workerId)
+	tested := hb.TotalTested - w.lastTotal
+	if tested < 0 {
+		tested = hb.TotalTested
+	}
+	w.lastTotal = hb.TotalTested
+	w.tested += tested
+	s.metrics.Tested += tested
+
+	// CPU use over the heartbeat window, across all cores
+	if window := time.Duration(hb.WindowNanos); window > 0 && hb.CPUSeconds >= w.cpuSeconds && w.cpuSeconds > 0 {
+		w.cpuPercent = 100 * (hb.CPUSeconds - w.cpuSeconds) / window.Seconds()
+	}
+	w.cpuSeconds = hb.CPUSeconds
+	w.rssBytes = hb.RSSBytes
+
+	if hb.Threads > 0 {
+		w.threads = hb.Threads
+	}
+	w.threadsActive = hb.ThreadsActive
+	w.threadTested = hb.ThreadTested
+	w.rate = hb.CurrentRate
+	w.lastHeartbeat = time.Now()
+
+	if len(s.samples) < maxRateSamples {
+		s.samples = append(s.samples, rateSample{
+			At:       w.lastHeartbeat,
+			WorkerId: workerId,
+			Rate:     hb.CurrentRate,
+			Tested:   tested,
+			Window:   time.Duration(hb.WindowNanos),
+		})
+	}
+
+	// Ignore progress on a chunk the worker has since been moved off
+	if a, ok := s.assignments[workerId]; ok && hb.JobStart == a.sentStart {
+		a.lastCompleted = max(a.lastCompleted, min(hb.LastCompleted, a.end-1))
+	}
+}
+
+// disconnected keeps the worker's chunk so it can be resumed on reconnect
+func (s *session) disconnected(workerId string, writeCh chan<- protocol.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[workerId] == writeCh {
+		delete(s.conns, workerId)
+		s.cancelAckedLocked(workerId)
+	}
+}
+
+// left drops a worker that said it is going away, or went silent, and hands
+// the rest of its chunk to a connected worker with nothing to do, if there is
+// one whose queue has room; otherwise the chunk waits for the next worker to
+// ask for work
+func (s *session) left(workerId string, writeCh chan<- protocol.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[workerId] != writeCh {
+		return
+	}
+	delete(s.conns, workerId)
+	s.cancelAckedLocked(workerId)
+
+	a, ok := s.assignments[workerId]
+	if !ok || s.solved {
+		return
+	}
+	for idleId, idleCh := range s.conns {
+		if _, busy := s.assignments[idleId]; busy {
+			continue
+		}
+		// The chunk only moves once the job is queued, a worker that never
+		// hears of it would hold on to it for as long as it stays connected
+		select {
+		case idleCh <- protocol.Message{Command: protocol.MsgJob, Job: s.jobLocked(a)}:
+		default:
+			continue
+		}
+		delete(s.assignments, workerId)
+		a.workerId = idleId
+		s.assignments[idleId] = a
+		return
+	}
+}
+
+// stopWorkers tells every connected worker to shut down and, like cancelJob,
+// leaves awaitCancelled to wait for them to send their final heartbeat and
+// hang up
+func (s *session) stopWorkers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cancelling) == 0 {
+		s.cancelled = make(chan struct{})
+	}
+	msg := protocol.Message{Command: protocol.MsgShutdown}
+	for workerId, writeCh := range s.conns {
+		select {
+		case writeCh <- msg:
+			s.cancelling[workerId] = struct{}{}
+		default:
+		}
+	}
+	if len(s.cancelling) == 0 {
+		close(s.cancelled)
+	}
+}
+
+// broadcast queues msg for every connected worker
+func (s *session) broadcast(msg protocol.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, writeCh := range s.conns {
+		select {
+		case writeCh <- msg:
+		default:
+		}
+	}
+}
+
+// cancelJob tells every other worker holding a chunk of the job to stop
+func (s *session) cancelJob(finder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.solved = true
+	if len(s.cancelling) == 0 {
+		s.cancelled = make(chan struct{})
+	}
+	msg := protocol.Message{
+		Command: protocol.MsgCancel,
+		Cancel:  &protocol.CancelRequest{JobId: s.job.Id},
+	}
+	for workerId := range s.assignments {
+		writeCh, ok := s.conns[workerId]
+		if workerId == finder || !ok {
+			continue
+		}
+		select {
+		case writeCh <- msg:
+			s.cancelling[workerId] = struct{}{}
+		default:
+		}
+	}
+	if len(s.cancelling) == 0 {
+		close(s.cancelled)
+	}
+}
+
+// cancelAcked records how far a cancelled worker got
+func (s *session) cancelAcked(workerId string, lastCompleted int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.assignments[workerId]; ok {
+		a.lastCompleted = max(a.lastCompleted, min(lastCompleted, a.end-1))
+	}
+	s.cancelAckedLocked(workerId)
+}
+
+// cancelAckedLocked drops workerId from the workers being waited on. Callers
+// must hold s.mu.
+func (s *session) cancelAckedLocked(workerId string) {
+	if _, ok := s.cancelling[workerId]; !ok {
+		return
+	}
+	delete(s.cancelling, workerId)
+	if len(s.cancelling) == 0 {
+		close(s.cancelled)
+	}
+}
+
+// awaitCancelled waits up to timeout for workers told to cancel or shut down
+// to report back and returns false if some never did
+func (s *session) awaitCancelled(timeout time.Duration) bool {
+	s.mu.Lock()
+	cancelled := s.cancelled
+	s.mu.Unlock()
+
+	if cancelled == nil {
+		return true
+	}
+	select {
+	case <-cancelled:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// cracked records the password found for the session's job
+func (s *session) cracked(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results = append(s.results, crackedHash{
+		JobId:    s.job.Id,
+		Username: s.job.Username,
+		Password: password,
+		FoundAt:  time.Now(),
+	})
+}
+
+// result returns the password already found for the session's job, if any
+func (s *session) result() (crackedHash, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.results {
+		if r.JobId == s.job.Id {
+			return r, true
+		}
+	}
+	return crackedHash{}, false
+}
+
+// coveredLocked counts the candidates of the job known to be tested, from
+// finished chunks and the progress reported on the ones in flight. Callers
+// must hold s.mu.
+func (s *session) coveredLocked() int64 {
+	var covered int64
+	for _, r := range s.completed {
+		if r.JobId == s.job.Id {
+			covered += r.End - r.Start
+		}
+	}
+	for _, a := range s.assignments {
+		covered += a.lastCompleted + 1 - a.start
+	}
+	return covered
+}
+
+// keyspaceSize is the number of candidates in job, 0 when it is unbounded
+func keyspaceSize(job protocol.CrackingJob) int64 {
+	if job.End == 0 {
+		return 0
+	}
+	return job.End - job.Start
+}
+
+// Progress is how far along a target's job is. HasPercent and HasETA say
+// whether those could be worked out yet. A job without a max length has no
+// end, so its Total only runs to the last candidate of Length, the length
+// handed out so far.
+type Progress struct {
+	Tested     int64
+	Total      int64
+	Length     int
+	Percent    float64
+	HasPercent bool
+	Rate       float64
+	ETA        time.Duration
+	HasETA     bool
+}
+
+// updateProgress folds the candidates tested so far into the estimate and
+// reports it
+func (s *session) updateProgress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.estimate.observe(s.testedLocked(), time.Now())
+	return s.progressLocked()
+}
+
+// testedLocked counts what the heartbeats reported, or the finished ranges
+// when those are further along since heartbeats lag behind chunk results
+func (s *session) testedLocked() int64 {
+	return max(s.metrics.Tested, s.coveredLocked())
+}
+
+// progressLocked reports the current estimate. Callers must hold s.mu.
+func (s *session) progressLocked() Progress {
+	r := Progress{
+		Tested: s.testedLocked(),
+		Total:  keyspaceSize(s.job),
+		Rate:   s.estimate.rate,
+	}
+	if r.Total == 0 {
+		r.Total, r.Length = s.lengthBoundLocked()
+	}
+	if r.Rate <= 0 {
+		// Nothing measured yet, go by the rates the workers benchmarked
+		r.Rate = s.connectedRateLocked()
+	}
+	r.Percent, r.HasPercent = s.estimate.percent(r.Tested, r.Total)
+	r.ETA, r.HasETA = s.estimate.eta(r.Tested, r.Total, r.Rate)
+	return r
+}
+
+// lengthBoundLocked is the size of an unbounded job's keyspace up to the end
+// of the longest candidates handed out so far, and that length. Callers must
+// hold s.mu.
+func (s *session) lengthBoundLocked() (int64, int) {
+	charset := s.job.Charset
+	if charset == "" {
+		charset = keyspace.DefaultCharset
+	}
+	length := len(keyspace.Indices(len(charset), max(s.next-1, s.job.Start)))
+	if length >= keyspace.MaxLength(len(charset)) {
+		return math.MaxInt64 - s.job.Start, length
+	}
+	return keyspace.Offset(len(charset), length+1) - s.job.Start, length
+}
+
+// connectedRateLocked adds up the smoothed rates of the connected workers.
+// Callers must hold s.mu.
+func (s *session) connectedRateLocked() float64 {
+	var rate float64
+	for id := range s.conns {
+		rate += s.rates[id]
+	}
+	return rate
+}
+
+// workerView is one worker's line in a sessionView
+type workerView struct {
+	WorkerId      string
+	Connected     bool
+	Threads       int
+	ThreadsActive int64
+	Rate          float64
+	Tested        int64
+	CPUSeconds    float64
+	CPUPercent    float64
+	RSSBytes      int64
+	LastHeartbeat time.Time
+}
+
+// sessionView is a consistent copy of the session for display
+type sessionView struct {
+	Job        protocol.CrackingJob
+	Workers    []workerView
+	Covered    int64
+	Dispatched int64
+	Rate       float64
+	Progress   Progress
+	Elapsed    time.Duration
+	Results    []crackedHash
+}
+
+// view copies out what the dashboard and reports show
+func (s *session) view() sessionView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := sessionView{
+		Job:        s.job,
+		Covered:    s.coveredLocked(),
+		Dispatched: s.next - s.job.Start,
+		Progress:   s.progressLocked(),
+		Elapsed:    s.metrics.Elapsed + time.Since(s.started),
+		Results:    append([]crackedHash{}, s.results...),
+	}
+	v.Rate = s.connectedRateLocked()
+	for id, w := range s.workers {
+		_, connected := s.conns[id]
+		v.Workers = append(v.Workers, workerView{
+			WorkerId:      id,
+			Connected:     connected,
+			Threads:       w.threads,
+			ThreadsActive: w.threadsActive,
+			Rate:          w.rate,
+			Tested:        w.tested,
+			CPUSeconds:    w.cpuSeconds,
+			CPUPercent:    w.cpuPercent,
+			RSSBytes:      w.rssBytes,
+			LastHeartbeat: w.lastHeartbeat,
+		})
+	}
+	sort.Slice(v.Workers, func(i, j int) bool { return v.Workers[i].WorkerId < v.Workers[j].WorkerId })
+	return v
+}
+
+// rateSamples copies out the heartbeat rates recorded so far
+func (s *session) rateSamples() []rateSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]rateSample{}, s.samples...)
+}
+
+// snapshot captures the session as a checkpoint
+func (s *session) snapshot() *checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := &checkpoint{
+		Version:   checkpointVersion,
+		SavedAt:   time.Now(),
+		Jobs:      []protocol.CrackingJob{s.job},
+		Next:      s.next,
+		Completed: append([]keyRange{}, s.completed...),
+		Pending:   []keyRange{},
+		Results:   append([]crackedHash{}, s.results...),
+		Metrics:   s.metrics,
+	}
+	cp.Metrics.Elapsed += time.Since(s.started)
+
+	for _, a := range s.assignments {
+		next := a.lastCompleted + 1
+		if next > a.start {
+			cp.Completed = append(cp.Completed, keyRange{JobId: s.job.Id, WorkerId: a.workerId, Start: a.start, End: next})
+		}
+		if next < a.end {
+			cp.Pending = append(cp.Pending, keyRange{JobId: s.job.Id, WorkerId: a.workerId, Start: next, End: a.end})
+		}
+	}
+	if s.job.End == 0 || s.next < s.job.End {
+		cp.Pending = append(cp.Pending, keyRange{JobId: s.job.Id, Start: s.next, End: s.job.End})
+	}
+	return cp
+}
+
+// finish marks the job as over so connection handlers stop reporting
+func (s *session) finish() {
+	close(s.done)
+}
+
+// release drops every worker connection without shutting the workers down,
+// leaving them to wait for the next controller
+func (s *session) release() {
+	close(s.released)
+}
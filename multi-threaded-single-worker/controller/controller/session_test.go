@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"cracker/protocol"
+)
+
+func TestBenchSizesFirstChunk(t *testing.T) {
+	job := protocol.CrackingJob{Id: 1, Setting: "$1$salt", FullHash: "$1$salt$hash", Start: 0, End: 1_000_000}
+	sess := newSession(job, 2*time.Second)
+	writeCh := make(chan protocol.Message, 1)
+
+	plain, err := sess.assign(&protocol.WorkerHello{WorkerId: "plain", Threads: 4}, writeCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := plain.End - plain.Start; n != 4*initialChunkPerThread {
+		t.Fatalf("first chunk without a benchmark is %d, want %d", n, 4*initialChunkPerThread)
+	}
+
+	// Only the rate for the job's algorithm counts
+	hello := &protocol.WorkerHello{WorkerId: "benched", Threads: 4, Bench: map[string]float64{"md5": 5000, "bcrypt": 40}}
+	benched, err := sess.assign(hello, writeCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := benched.End - benched.Start; n != 10000 {
+		t.Fatalf("first chunk after a 5000/s benchmark is %d, want 10000", n)
+	}
+
+	// The benchmark also gives an ETA before any heartbeat
+	p := sess.updateProgress()
+	if !p.HasETA || p.Rate != 5000 {
+		t.Fatalf("progress %+v, want an ETA at 5000/s", p)
+	}
+}
+
+func TestRejectedWorkerIsNotRegistered(t *testing.T) {
+	job := protocol.CrackingJob{Id: 1, Setting: "$1$salt", FullHash: "$1$salt$hash", Start: 0, End: 10}
+	sess := newSession(job, 2*time.Second)
+
+	if _, err := sess.assign(&protocol.WorkerHello{WorkerId: "first", Threads: 4}, make(chan protocol.Message, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sess.assign(&protocol.WorkerHello{WorkerId: "late", Threads: 4}, make(chan protocol.Message, 1)); err != errNoWork {
+		t.Fatalf("assign with the keyspace handed out: %v, want %v", err, errNoWork)
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if _, ok := sess.conns["late"]; ok {
+		t.Fatal("the rejected worker's connection is still registered")
+	}
+	if _, ok := sess.workers["late"]; ok {
+		t.Fatal("the rejected worker still has stats")
+	}
+}
+
+func TestLeftKeepsChunkWhenIdleQueueIsFull(t *testing.T) {
+	job := protocol.CrackingJob{Id: 1, Setting: "$1$salt", FullHash: "$1$salt$hash", Start: 0, End: 1_000_000}
+	sess := newSession(job, 2*time.Second)
+
+	leaving := make(chan protocol.Message, 1)
+	chunk, err := sess.assign(&protocol.WorkerHello{WorkerId: "leaving", Threads: 1}, leaving)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A connected worker with nothing to do but no room in its queue
+	full := make(chan protocol.Message, 1)
+	full <- protocol.Message{Command: protocol.MsgHeartbeat}
+	sess.mu.Lock()
+	sess.conns["full"] = full
+	sess.mu.Unlock()
+
+	sess.left("leaving", leaving)
+	if _, ok := sess.assignments["full"]; ok {
+		t.Fatal("the chunk went to a worker whose queue was full")
+	}
+
+	// The next worker to ask takes the chunk over
+	next, err := sess.assign(&protocol.WorkerHello{WorkerId: "next", Threads: 1}, make(chan protocol.Message, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Start != chunk.Start || next.End != chunk.End {
+		t.Fatalf("next worker got [%d, %d), want the orphaned [%d, %d)", next.Start, next.End, chunk.Start, chunk.End)
+	}
+
+	// An idle worker with room is handed the chunk at once
+	idle := make(chan protocol.Message, 1)
+	sess.mu.Lock()
+	sess.conns["idle"] = idle
+	sess.mu.Unlock()
+	sess.left("next", sess.conns["next"])
+	select {
+	case msg := <-idle:
+		if msg.Job.Start != chunk.Start {
+			t.Fatalf("idle worker got a job from %d, want %d", msg.Job.Start, chunk.Start)
+		}
+	default:
+		t.Fatal("the idle worker was not sent the chunk")
+	}
+	if a := sess.assignments["idle"]; a == nil || a.start != chunk.Start {
+		t.Fatal("the chunk is not assigned to the idle worker")
+	}
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/swarm"
+)
+
+func TestHarnessSwarm(t *testing.T) {
+	// Far more workers than chunks the first pass needs, so some are turned
+	// away and keep coming back
+	job := bruteForceJob(t, "shadow_ACE_md5", keyspace.DefaultCharset, 4)
+	h := newHarness(t, job)
+
+	cfg := swarm.DefaultConfig()
+	cfg.Workers = 300
+	cfg.Rate = 2e5
+	cfg.Find = job.Start + (job.End-job.Start)*3/4
+	cfg.Retry = 50 * time.Millisecond
+	want := keyspace.Candidate(job.Charset, cfg.Find)
+
+	stop := make(chan struct{})
+	reports := make(chan swarm.Report)
+	go func() {
+		reports <- swarm.Run(cfg, func() (net.Conn, error) { return h.connect(), nil }, stop, h.log)
+	}()
+
+	result := h.run()
+	close(stop)
+	report := <-reports
+
+	if result.Password != want {
+		t.Fatalf("got %+v, want %q", result, want)
+	}
+	if report.Found != want {
+		t.Fatalf("swarm found %q, want %q", report.Found, want)
+	}
+	if report.Jobs < int64(cfg.Workers) || len(report.Dispatch) == 0 {
+		t.Fatalf("%d jobs handed out, %d dispatches timed", report.Jobs, len(report.Dispatch))
+	}
+	t.Logf("%d messages, %.0f/s, dispatch p50 %s p99 %s", report.Sent+report.Received, report.MessageRate(), report.Percentile(0.5), report.Percentile(0.99))
+}
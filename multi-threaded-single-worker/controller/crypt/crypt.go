@@ -0,0 +1,127 @@
+// Package crypt calls the system's crypt(3) through libcrypt, so any scheme
+// the shadow file can hold can be hashed and checked
+package crypt
+
+/*
+#cgo LDFLAGS: -lcrypt
+#include <stdlib.h>
+#include <string.h>
+#include <crypt.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Prefixes picks the setting prefix for the schemes Salt knows by name
+var Prefixes = map[string]string{
+	"md5":      "$1$",
+	"sha256":   "$5$",
+	"sha512":   "$6$",
+	"bcrypt":   "$2b$",
+	"yescrypt": "$y$",
+}
+
+// Hash hashes key with setting, which is a full hash or just its salt part.
+// It is safe to call from many goroutines.
+func Hash(key, setting string) (string, error) {
+	data := C.struct_crypt_data{}
+	cKey := C.CString(key)
+	cSetting := C.CString(setting)
+	defer C.free(unsafe.Pointer(cKey))
+	defer C.free(unsafe.Pointer(cSetting))
+
+	res := C.crypt_r(cKey, cSetting, &data)
+	if res == nil {
+		return "", errors.New("crypt_r failed")
+	}
+	hash := C.GoString(res)
+	// Some libcrypts report failure with a hash starting with *
+	if len(hash) > 0 && hash[0] == '*' {
+		return "", fmt.Errorf("crypt_r rejected setting %q", setting)
+	}
+	return hash, nil
+}
+
+// Verify reports whether key hashes to fullHash
+func Verify(key, fullHash string) (bool, error) {
+	hash, err := Hash(key, fullHash)
+	if err != nil {
+		return false, err
+	}
+	return hash == fullHash, nil
+}
+
+// Checker checks keys against one hash, reusing its buffers from one key to
+// the next so checking allocates nothing. It is not safe for concurrent use,
+// each cracking thread gets its own.
+type Checker struct {
+	data    *C.struct_crypt_data
+	setting *C.char
+	key     *C.char
+	keyCap  int
+	hash    string
+}
+
+// NewChecker prepares to check keys against fullHash. Close frees it.
+func NewChecker(fullHash string) *Checker {
+	return &Checker{
+		// crypt_r wants the data zeroed before its first use only
+		data:    (*C.struct_crypt_data)(C.calloc(1, C.sizeof_struct_crypt_data)),
+		setting: C.CString(fullHash),
+		hash:    fullHash,
+	}
+}
+
+// Check reports whether key hashes to the checker's hash
+func (c *Checker) Check(key []byte) (bool, error) {
+	if len(key)+1 > c.keyCap {
+		C.free(unsafe.Pointer(c.key))
+		c.keyCap = max(2*(len(key)+1), 32)
+		c.key = (*C.char)(C.malloc(C.size_t(c.keyCap)))
+	}
+	keyBuf := unsafe.Slice((*byte)(unsafe.Pointer(c.key)), c.keyCap)
+	copy(keyBuf, key)
+	keyBuf[len(key)] = 0
+
+	res := C.crypt_r(c.key, c.setting, c.data)
+	if res == nil {
+		return false, errors.New("crypt_r failed")
+	}
+	n := int(C.strlen(res))
+	hash := unsafe.Slice((*byte)(unsafe.Pointer(res)), n)
+	if n > 0 && hash[0] == '*' {
+		return false, fmt.Errorf("crypt_r rejected setting %q", c.hash)
+	}
+	return string(hash) == c.hash, nil
+}
+
+// Close frees the checker's buffers
+func (c *Checker) Close() {
+	C.free(unsafe.Pointer(c.data))
+	C.free(unsafe.Pointer(c.setting))
+	C.free(unsafe.Pointer(c.key))
+	*c = Checker{}
+}
+
+// Salt makes a fresh random setting for the named scheme
+func Salt(algorithm string) (string, error) {
+	prefix, ok := Prefixes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+
+	var buf [C.CRYPT_GENSALT_OUTPUT_SIZE]C.char
+	cPrefix := C.CString(prefix)
+	defer C.free(unsafe.Pointer(cPrefix))
+
+	// A zero count and no random bytes ask for the default cost and OS randomness
+	res := C.crypt_gensalt_rn(cPrefix, 0, nil, 0, &buf[0], C.int(len(buf)))
+	if res == nil {
+		return "", fmt.Errorf("crypt_gensalt failed for %s", algorithm)
+	}
+	return C.GoString(res), nil
+}
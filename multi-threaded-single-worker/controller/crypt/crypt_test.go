@@ -0,0 +1,59 @@
+package crypt
+
+import (
+	"testing"
+)
+
+const md5Hash = "$1$pXwmSMfy$30twZ0vgFgX9gYZRENbqY."
+
+func TestChecker(t *testing.T) {
+	setting, err := Salt("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := Hash("secret", setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewChecker(hash)
+	defer c.Close()
+	for _, tt := range []struct {
+		key  string
+		want bool
+	}{
+		{"secret", true},
+		{"Secret", false},
+		{"a much longer key than the checker's first buffer holds", false},
+		{"", false},
+		{"secret", true},
+	} {
+		ok, err := c.Check([]byte(tt.key))
+		if err != nil || ok != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.key, ok, err, tt.want)
+		}
+	}
+
+	bad := NewChecker("$9$nonsense")
+	defer bad.Close()
+	if _, err := bad.Check([]byte("x")); err == nil {
+		t.Error("unknown scheme checked without an error")
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Verify("ABCDE", md5Hash)
+	}
+}
+
+func BenchmarkChecker(b *testing.B) {
+	c := NewChecker(md5Hash)
+	defer c.Close()
+	key := []byte("ABCDE")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Check(key)
+	}
+}
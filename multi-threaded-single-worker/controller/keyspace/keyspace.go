@@ -0,0 +1,91 @@
+// Package keyspace maps brute force candidates to and from a flat index, so
+// that ranges of the search can be handed out, resumed and measured.
+//
+// Candidates are ordered by length first and then by charset position, which
+// is the order the worker generates them in: with the default charset index 0
+// is "A", index 78 is "?" and index 79 is "AA".
+package keyspace
+
+import "math"
+
+const DefaultCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ" + "abcdefghijklmnopqrstuvwxyz" + "0123456789" + "@#%^&*()_+-=.,:;?"
+
+// Indices returns the per position charset indices of the candidate at index
+// for a charset of the given size.
+func Indices(size int, index int64) []int {
+	length := 1
+	block := int64(size)
+	for index >= block {
+		index -= block
+		length++
+		if block > math.MaxInt64/int64(size) {
+			break
+		}
+		block *= int64(size)
+	}
+
+	p := make([]int, length)
+	for i := length - 1; i >= 0; i-- {
+		p[i] = int(index % int64(size))
+		index /= int64(size)
+	}
+	return p
+}
+
+// Index is the inverse of Indices.
+func Index(size int, p []int) int64 {
+	offset := Offset(size, len(p))
+
+	var value int64
+	for _, idx := range p {
+		value = value*int64(size) + int64(idx)
+	}
+	return offset + value
+}
+
+// Candidate returns the candidate string at index.
+func Candidate(charset string, index int64) string {
+	p := Indices(len(charset), index)
+	buf := make([]byte, len(p))
+	for i, idx := range p {
+		buf[i] = charset[idx]
+	}
+	return string(buf)
+}
+
+// Offset returns the index of the first candidate of the given length, which
+// is also the number of candidates shorter than it.
+func Offset(size int, length int) int64 {
+	var offset, block int64 = 0, 1
+	for i := 1; i < length; i++ {
+		block *= int64(size)
+		offset += block
+	}
+	return offset
+}
+
+// MaxLength returns the longest candidate length whose indices all fit in an
+// int64 for a charset of the given size, 0 for charsets too small to index.
+func MaxLength(size int) int {
+	if size < 2 {
+		return 0
+	}
+	length := 0
+	var offset, block int64 = 0, 1
+	for block <= (math.MaxInt64-offset)/int64(size) {
+		block *= int64(size)
+		offset += block
+		length++
+	}
+	return length
+}
+
+// Bounds returns the index range [start, end) of the candidates between
+// minLength and maxLength long, a maxLength of 0 leaves end unbounded at 0.
+func Bounds(size int, minLength int, maxLength int) (start int64, end int64) {
+	start = Offset(size, minLength)
+	if maxLength > 0 {
+		end = Offset(size, maxLength+1)
+	}
+	return start, end
+}
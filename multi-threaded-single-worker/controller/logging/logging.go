@@ -0,0 +1,105 @@
+// Package logging sets up the leveled, structured loggers the controller and
+// worker write through
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Flags are the logging options shared by every binary
+type Flags struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// Register adds -log-format and -log-level to fs
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Format, "log-format", "text", "log format, text or json")
+	fs.StringVar(&f.Level, "log-level", "info", "lowest level to log: debug, info, warn or error; per-message logs are debug")
+}
+
+// Check reports whether f names a known format and level
+func (f Flags) Check() error {
+	_, err := f.level()
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(f.Format) {
+	case "text", "json":
+		return nil
+	}
+	return fmt.Errorf("log format %q: want text or json", f.Format)
+}
+
+func (f Flags) level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.Level)); err != nil {
+		return level, fmt.Errorf("log level %q: want debug, info, warn or error", f.Level)
+	}
+	return level, nil
+}
+
+// New builds a logger writing to out, tagging every record with component
+func New(out io.Writer, f Flags, component string) (*slog.Logger, error) {
+	level, err := f.level()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: shortSource,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(f.Format) {
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("log format %q: want text or json", f.Format)
+	}
+	return slog.New(handler).With("component", component), nil
+}
+
+// shortSource trims the source path down to file:line
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.SourceKey || len(groups) > 0 {
+		return a
+	}
+	if src, ok := a.Value.Any().(*slog.Source); ok {
+		a.Value = slog.StringValue(fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+	}
+	return a
+}
+
+// Output is a writer loggers can hold on to while what it writes to changes
+type Output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewOutput(w io.Writer) *Output {
+	return &Output{w: w}
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+// SetOutput sends everything written from now on to w
+func (o *Output) SetOutput(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w = w
+}
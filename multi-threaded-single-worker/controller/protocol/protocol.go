@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -17,29 +18,80 @@ const (
 	MsgResult    Command = "result"
 	MsgError     Command = "error"
 	MsgShutdown  Command = "shutdown"
+	MsgCancel    Command = "cancel"
+
+	// MsgLeave is sent by a worker that is going away, with a final
+	// Heartbeat, so the controller can hand the rest of its range on
+	MsgLeave Command = "leave"
 )
 
 type Message struct {
 	Command Command `json:"command"`
 
+	Hello     *WorkerHello       `json:"hello,omitempty"`
 	Job       *CrackingJob       `json:"job,omitempty"`
 	Result    *CrackResult       `json:"result,omitempty"`
 	Heartbeat *HeartbeatResponse `json:"heartbeat,omitempty"`
+	Cancel    *CancelRequest     `json:"cancel,omitempty"`
 	Error     string             `json:"error,omitempty"`
 }
 
+// Encoder and Decoder carry messages over a connection. Both are satisfied by
+// the encoding/json types and can be wrapped, e.g. to record the traffic.
+type Encoder interface {
+	Encode(v any) error
+}
+
+type Decoder interface {
+	Decode(v any) error
+}
+
+// WorkerHello is sent with MsgReady every time a worker (re)connects. Bench
+// holds the hashes per second the worker measured for each algorithm, named
+// as Algorithm names them, when it ran a benchmark at startup.
+type WorkerHello struct {
+	WorkerId string             `json:"worker_id"`
+	Threads  int                `json:"threads"`
+	Resume   *ResumePoint       `json:"resume,omitempty"`
+	Bench    map[string]float64 `json:"bench,omitempty"`
+}
+
+// ResumePoint tells the controller how far a worker got on a job before its
+// connection dropped
+type ResumePoint struct {
+	JobId         int   `json:"job_id"`
+	LastCompleted int64 `json:"last_completed"`
+}
+
+// CrackingJob covers the keyspace indices [Start, End), End 0 is unbounded.
+// A wordlist job indexes the lines of the controller's Wordlist instead and
+// carries the words of its chunk.
 type CrackingJob struct {
 	Id       int
 	Interval int
 	Username string
 	Setting  string
 	FullHash string
+	Charset  string
+	Start    int64
+	End      int64
+	Wordlist string   `json:",omitempty"`
+	Words    []string `json:",omitempty"`
+}
+
+// CancelRequest sent from Controller -> Worker once a job's password is found
+type CancelRequest struct {
+	JobId int `json:"job_id"`
 }
 
-// CrackResult sent from Worker -> Controller
+// CrackResult sent from Worker -> Controller. A cancelled job reports how far
+// the worker got instead of a password.
 type CrackResult struct {
-	Password string        `json:"password"`
-	Metrics  WorkerMetrics `json:"metrics"`
+	JobId         int           `json:"job_id"`
+	Password      string        `json:"password"`
+	Cancelled     bool          `json:"cancelled,omitempty"`
+	LastCompleted int64         `json:"last_completed"`
+	Metrics       WorkerMetrics `json:"metrics"`
 }
 
 type WorkerMetrics struct {
@@ -48,11 +100,23 @@ type WorkerMetrics struct {
 	WorkerSentResultsNanos time.Time `json:"worker_sent_results_ns"`
 }
 
+// HeartbeatResponse covers the window since the worker's previous heartbeat.
+// Threads is how many threads the worker cracks with, which a worker tuning
+// its thread count settles per job, and ThreadsActive how many of them are
+// working on one. CPUSeconds and RSSBytes come from /proc/self, zero where
+// that is not available.
 type HeartbeatResponse struct {
 	DeltaTested   int64   `json:"delta_tested"`
 	TotalTested   int64   `json:"total_tested"`
+	ThreadTested  []int64 `json:"thread_tested,omitempty"`
+	Threads       int     `json:"threads,omitempty"`
 	ThreadsActive int64   `json:"threads_active"`
 	CurrentRate   float64 `json:"current_rate"`
+	WindowNanos   int64   `json:"window_nanos"`
+	CPUSeconds    float64 `json:"cpu_seconds"`
+	RSSBytes      int64   `json:"rss_bytes"`
+	JobStart      int64   `json:"job_start"`
+	LastCompleted int64   `json:"last_completed"`
 }
 
 func FindUserInShadow(filePath string, username string) (*CrackingJob, error) {
@@ -81,24 +145,55 @@ func FindUserInShadow(filePath string, username string) (*CrackingJob, error) {
 	return nil, fmt.Errorf("user %q not found in shadow file", username)
 }
 
+// ParseHash creates a CrackingJob for a crypt hash given on its own
+func ParseHash(username string, fullHash string) (*CrackingJob, error) {
+	return parseShadowLine(username + ":" + fullHash)
+}
+
+// Reasons a shadow line or hash is rejected, wrapped in a ShadowError
+var (
+	ErrMalformedLine   = errors.New("invalid shadow line format")
+	ErrNoPassword      = errors.New("account has no valid password")
+	ErrUnsupportedHash = errors.New("unsupported hash format")
+)
+
+// ShadowError says why the shadow line for Username could not be cracked
+type ShadowError struct {
+	Username string
+	Err      error
+}
+
+func (e *ShadowError) Error() string {
+	if e.Username == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("user %s: %v", e.Username, e.Err)
+}
+
+func (e *ShadowError) Unwrap() error {
+	return e.Err
+}
+
 // Parse's Shadow line and creates CrackingJob
 func parseShadowLine(line string) (*CrackingJob, error) {
 	fields := strings.Split(line, ":")
-	if len(fields) < 2 {
-		return nil, fmt.Errorf("invalid shadow line format")
+	if len(fields) < 2 || fields[0] == "" {
+		return nil, &ShadowError{Err: ErrMalformedLine}
 	}
 
 	username := fields[0]
 	fullHash := fields[1]
 
 	// Locked / disabled accounts
-	if fullHash == "!" || fullHash == "*" {
-		return nil, fmt.Errorf("account %s has no valid password", username)
+	if fullHash == "" || strings.HasPrefix(fullHash, "!") || fullHash == "*" {
+		return nil, &ShadowError{Username: username, Err: ErrNoPassword}
 	}
 
-	// crypt format always starts with $
-	if !strings.HasPrefix(fullHash, "$") {
-		return nil, fmt.Errorf("unsupported hash format for user %s", username)
+	// crypt format is $id$...$hash: it always starts with $, names the
+	// scheme and ends in a non-empty hash after the last $
+	parts := strings.Split(fullHash, "$")
+	if len(parts) < 3 || parts[0] != "" || parts[1] == "" || parts[len(parts)-1] == "" {
+		return nil, &ShadowError{Username: username, Err: ErrUnsupportedHash}
 	}
 
 	// Remove trailing hash part to get the setting
@@ -113,3 +208,27 @@ func parseShadowLine(line string) (*CrackingJob, error) {
 		FullHash: fullHash,
 	}, nil
 }
+
+// Algorithm names the crypt scheme a hash or setting uses, from its $id$
+// prefix
+func Algorithm(setting string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(setting, "$"), "$")
+	switch id {
+	case "1":
+		return "md5"
+	case "5":
+		return "sha256"
+	case "6":
+		return "sha512"
+	case "2a", "2b", "2x", "2y":
+		return "bcrypt"
+	case "y":
+		return "yescrypt"
+	case "gy":
+		return "gost-yescrypt"
+	case "7":
+		return "scrypt"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"cracker/keyspace"
+)
+
+func TestParseShadowLineErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"", ErrMalformedLine},
+		{"aryan", ErrMalformedLine},
+		{":$1$salt$hash", ErrMalformedLine},
+		{"aryan:", ErrNoPassword},
+		{"aryan:!", ErrNoPassword},
+		{"aryan:!$1$salt$hash", ErrNoPassword},
+		{"aryan:*", ErrNoPassword},
+		{"aryan:plain", ErrUnsupportedHash},
+		{"aryan:$", ErrUnsupportedHash},
+		{"aryan:$$", ErrUnsupportedHash},
+		{"aryan:$1$", ErrUnsupportedHash},
+		{"aryan:$$salt$hash", ErrUnsupportedHash},
+		{"aryan:$1$salt$", ErrUnsupportedHash},
+	}
+	for _, tt := range tests {
+		_, err := parseShadowLine(tt.line)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("parseShadowLine(%q) = %v, want %v", tt.line, err, tt.want)
+		}
+		var shadowErr *ShadowError
+		if !errors.As(err, &shadowErr) {
+			t.Errorf("parseShadowLine(%q) = %T, want a *ShadowError", tt.line, err)
+		}
+	}
+}
+
+func TestValidateMissingPayloads(t *testing.T) {
+	tests := []struct {
+		msg        string
+		fromWorker bool
+		want       error
+	}{
+		{`{"command":"result"}`, true, ErrMissingField},
+		{`{"command":"heartbeat"}`, true, ErrMissingField},
+		{`{"command":"heartbeat","heartbeat":{"total_tested":-1}}`, true, ErrInvalidField},
+		{`{"command":"ready","hello":{"threads":-4}}`, true, ErrInvalidField},
+		{`{"command":"ready","hello":{"threads":4,"bench":{"md5":-1}}}`, true, ErrInvalidField},
+		{`{"command":"job"}`, true, ErrUnknownCommand},
+		{`{"command":"job"}`, false, ErrMissingField},
+		{`{"command":"cancel"}`, false, ErrMissingField},
+		{`{"command":"job","job":{"Start":-5}}`, false, ErrInvalidField},
+		{`{"command":"job","job":{"Charset":"A","Start":10}}`, false, ErrInvalidField},
+		{`{"command":"job","job":{"Start":10,"End":5}}`, false, ErrInvalidField},
+		{`{"command":"job","job":{"Start":0,"End":3,"Words":["a"]}}`, false, ErrInvalidField},
+		{`{"command":"ready"}`, false, ErrUnknownCommand},
+	}
+	for _, tt := range tests {
+		var msg Message
+		if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+			t.Fatal(err)
+		}
+		err := msg.ValidateFromController()
+		if tt.fromWorker {
+			err = msg.ValidateFromWorker()
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.msg, err, tt.want)
+		}
+		var msgErr *MessageError
+		if !errors.As(err, &msgErr) || msgErr.Command != msg.Command {
+			t.Errorf("%s: got %#v, want a *MessageError for %q", tt.msg, err, msg.Command)
+		}
+	}
+}
+
+func FuzzParseShadowLine(f *testing.F) {
+	f.Add("aryan:$1$pXwmSMfy$30twZ0vgFgX9gYZRENbqY.:19000:0:99999:7:::")
+	f.Add("aryan:$2b$05$8ghRQzt5qJAhc3hKhdClhOCtw6iQkBF6upvwBpxMTS5bx9GGIBjim:19000:0:99999:7:::")
+	f.Add("aryan:$y$j9T$salt$hash:19000::::::")
+	f.Add("root:!:19000::::::")
+	f.Add("daemon:*:19000::::::")
+	f.Add("x:$")
+	f.Add(":")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		job, err := parseShadowLine(line)
+		if err != nil {
+			var shadowErr *ShadowError
+			if !errors.As(err, &shadowErr) {
+				t.Fatalf("untyped error %T: %v", err, err)
+			}
+			return
+		}
+		if job.Username == "" {
+			t.Fatalf("%q: empty username", line)
+		}
+		if !strings.HasPrefix(job.FullHash, job.Setting+"$") || len(job.FullHash) == len(job.Setting)+1 {
+			t.Fatalf("%q: setting %q is not a prefix of hash %q", line, job.Setting, job.FullHash)
+		}
+		if Algorithm(job.Setting) == "" {
+			t.Fatalf("%q: no algorithm name", line)
+		}
+	})
+}
+
+func FuzzValidateMessage(f *testing.F) {
+	f.Add([]byte(`{"command":"ready","hello":{"worker_id":"w1","threads":4}}`))
+	f.Add([]byte(`{"command":"heartbeat","heartbeat":{"delta_tested":10,"total_tested":20,"current_rate":5}}`))
+	f.Add([]byte(`{"command":"result","result":{"job_id":1,"password":"ACE"}}`))
+	f.Add([]byte(`{"command":"leave"}`))
+	f.Add([]byte(`{"command":"job","job":{"Id":1,"Charset":"AB","Start":3,"End":9}}`))
+	f.Add([]byte(`{"command":"job","job":{"Id":1,"Start":0,"End":2,"Words":["a","b"]}}`))
+	f.Add([]byte(`{"command":"cancel","cancel":{"job_id":1}}`))
+	f.Add([]byte(`{"command":"shutdown"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+
+		if err := msg.ValidateFromWorker(); err == nil {
+			switch msg.Command {
+			case MsgResult:
+				if msg.Result == nil {
+					t.Fatal("result message without a result passed")
+				}
+			case MsgHeartbeat:
+				if msg.Heartbeat == nil {
+					t.Fatal("heartbeat message without a heartbeat passed")
+				}
+			}
+		} else if !errors.As(err, new(*MessageError)) {
+			t.Fatalf("untyped error %T: %v", err, err)
+		}
+
+		if err := msg.ValidateFromController(); err == nil {
+			if msg.Command == MsgJob {
+				if msg.Job == nil {
+					t.Fatal("job message without a job passed")
+				}
+				// The first candidate of a valid job can always be built
+				if msg.Job.Words == nil {
+					charset := msg.Job.Charset
+					if charset == "" {
+						charset = keyspace.DefaultCharset
+					}
+					keyspace.Candidate(charset, msg.Job.Start)
+				}
+			}
+		} else if !errors.As(err, new(*MessageError)) {
+			t.Fatalf("untyped error %T: %v", err, err)
+		}
+	})
+}
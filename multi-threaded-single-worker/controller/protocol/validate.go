@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"cracker/keyspace"
+)
+
+// Reasons a message is rejected, wrapped in a MessageError
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrMissingField   = errors.New("missing")
+	ErrInvalidField   = errors.New("invalid")
+	ErrOutOfOrder     = errors.New("not expected yet")
+)
+
+// Most threads a worker can announce, far above any real machine but low
+// enough that chunk sizes derived from it cannot overflow
+const MaxThreads = 1 << 16
+
+// MessageError says what is wrong with a message a peer sent
+type MessageError struct {
+	Command Command
+	Field   string
+	Err     error
+	Detail  string
+}
+
+func (e *MessageError) Error() string {
+	msg := fmt.Sprintf("%q message", e.Command)
+	if e.Field != "" {
+		msg += fmt.Sprintf(": %s %v", e.Field, e.Err)
+	} else {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateFromWorker checks that a message a worker sent is one the
+// controller handles and carries what its command needs
+func (m *Message) ValidateFromWorker() error {
+	switch m.Command {
+	case MsgReady:
+		// The hello is optional, workers without one are served anonymously
+		h := m.Hello
+		if h == nil {
+			break
+		}
+		if h.Threads < 0 || h.Threads > MaxThreads {
+			return m.invalid("hello.threads", fmt.Sprintf("%d is not between 0 and %d", h.Threads, MaxThreads))
+		}
+		for algorithm, rate := range h.Bench {
+			if rate < 0 {
+				return m.invalid("hello.bench", fmt.Sprintf("negative rate for %s", algorithm))
+			}
+		}
+	case MsgResult:
+		if m.Result == nil {
+			return m.missing("result")
+		}
+	case MsgHeartbeat:
+		if m.Heartbeat == nil {
+			return m.missing("heartbeat")
+		}
+		return m.Heartbeat.validate(m)
+	case MsgLeave:
+		if m.Heartbeat != nil {
+			return m.Heartbeat.validate(m)
+		}
+	case MsgError:
+	default:
+		return &MessageError{Command: m.Command, Err: ErrUnknownCommand}
+	}
+	return nil
+}
+
+// ValidateFromController checks that a message the controller sent is one
+// the worker handles and carries what its command needs
+func (m *Message) ValidateFromController() error {
+	switch m.Command {
+	case MsgJob:
+		if m.Job == nil {
+			return m.missing("job")
+		}
+		return m.Job.validate(m)
+	case MsgCancel:
+		if m.Cancel == nil {
+			return m.missing("cancel")
+		}
+	case MsgHeartbeat, MsgShutdown, MsgError:
+	default:
+		return &MessageError{Command: m.Command, Err: ErrUnknownCommand}
+	}
+	return nil
+}
+
+func (m *Message) missing(field string) error {
+	return &MessageError{Command: m.Command, Field: field, Err: ErrMissingField}
+}
+
+func (m *Message) invalid(field string, detail string) error {
+	return &MessageError{Command: m.Command, Field: field, Err: ErrInvalidField, Detail: detail}
+}
+
+func (hb *HeartbeatResponse) validate(m *Message) error {
+	switch {
+	case hb.DeltaTested < 0:
+		return m.invalid("heartbeat.delta_tested", "negative")
+	case hb.TotalTested < 0:
+		return m.invalid("heartbeat.total_tested", "negative")
+	case hb.CurrentRate < 0:
+		return m.invalid("heartbeat.current_rate", "negative")
+	case hb.WindowNanos < 0:
+		return m.invalid("heartbeat.window_nanos", "negative")
+	case hb.Threads < 0 || hb.Threads > MaxThreads:
+		return m.invalid("heartbeat.threads", fmt.Sprintf("%d is not between 0 and %d", hb.Threads, MaxThreads))
+	}
+	return nil
+}
+
+// validate checks that the job's range can be generated: it must lie within
+// the indices its charset can reach, and a wordlist chunk must carry one
+// word per index
+func (j *CrackingJob) validate(m *Message) error {
+	if j.Words != nil {
+		if j.Start < 0 || j.End != j.Start+int64(len(j.Words)) {
+			return m.invalid("job.words", fmt.Sprintf("%d words for range [%d, %d)", len(j.Words), j.Start, j.End))
+		}
+		return nil
+	}
+
+	size := len(j.Charset)
+	if size == 0 {
+		size = len(keyspace.DefaultCharset)
+	}
+	if size < 2 {
+		return m.invalid("job.charset", "needs at least 2 characters")
+	}
+	limit := keyspace.Offset(size, keyspace.MaxLength(size)+1)
+	switch {
+	case j.Start < 0 || j.Start >= limit:
+		return m.invalid("job.start", fmt.Sprintf("%d is outside [0, %d)", j.Start, limit))
+	case j.End != 0 && (j.End < j.Start || j.End > limit):
+		return m.invalid("job.end", fmt.Sprintf("%d is outside [%d, %d]", j.End, j.Start, limit))
+	}
+	return nil
+}
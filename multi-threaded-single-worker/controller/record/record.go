@@ -0,0 +1,158 @@
+// Package record writes the protocol messages a controller or worker
+// exchanges to a JSONL file and plays such recordings back in place of the
+// peer, to reproduce protocol bugs without a network
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cracker/protocol"
+)
+
+// Sides of a connection
+const (
+	Controller = "controller"
+	Worker     = "worker"
+)
+
+// Directions a message went, as seen by the side that recorded it
+const (
+	Sent     = "send"
+	Received = "recv"
+)
+
+// Entry is one line of a recording
+type Entry struct {
+	Time      time.Time        `json:"time"`
+	Side      string           `json:"side"`
+	Peer      string           `json:"peer"`
+	Direction string           `json:"direction"`
+	Message   protocol.Message `json:"message"`
+}
+
+// Recorder appends every message passing through its encoders and decoders
+// to a file. A nil Recorder records nothing.
+type Recorder struct {
+	mu   sync.Mutex
+	side string
+	file *os.File
+	enc  *json.Encoder
+}
+
+// Create starts a recording at path for the given side, replacing any file
+// already there
+func Create(path string, side string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	return &Recorder{side: side, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *Recorder) record(peer, direction string, v any) {
+	var msg protocol.Message
+	switch m := v.(type) {
+	case protocol.Message:
+		msg = m
+	case *protocol.Message:
+		msg = *m
+	default:
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// A failed write only loses the recording, never the connection
+	r.enc.Encode(Entry{
+		Time:      time.Now(),
+		Side:      r.side,
+		Peer:      peer,
+		Direction: direction,
+		Message:   msg,
+	})
+}
+
+// Encoder records every message enc sends to peer
+func (r *Recorder) Encoder(enc protocol.Encoder, peer string) protocol.Encoder {
+	if r == nil {
+		return enc
+	}
+	return &recordingEncoder{enc: enc, rec: r, peer: peer}
+}
+
+// Decoder records every message dec receives from peer
+func (r *Recorder) Decoder(dec protocol.Decoder, peer string) protocol.Decoder {
+	if r == nil {
+		return dec
+	}
+	return &recordingDecoder{dec: dec, rec: r, peer: peer}
+}
+
+type recordingEncoder struct {
+	enc  protocol.Encoder
+	rec  *Recorder
+	peer string
+}
+
+func (e *recordingEncoder) Encode(v any) error {
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	e.rec.record(e.peer, Sent, v)
+	return nil
+}
+
+type recordingDecoder struct {
+	dec  protocol.Decoder
+	rec  *Recorder
+	peer string
+}
+
+func (d *recordingDecoder) Decode(v any) error {
+	if err := d.dec.Decode(v); err != nil {
+		return err
+	}
+	d.rec.record(d.peer, Received, v)
+	return nil
+}
+
+// Load reads a recording back
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,224 @@
+package record
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cracker/protocol"
+)
+
+// The worker's half of a short session and what the controller answers
+var (
+	ready          = protocol.Message{Command: protocol.MsgReady, Hello: &protocol.WorkerHello{WorkerId: "w", Threads: 2}}
+	job            = protocol.Message{Command: protocol.MsgJob, Job: &protocol.CrackingJob{Id: 1, Interval: 1, Username: "u", Setting: "$1$salt", FullHash: "$1$salt$hash", Charset: "AB", Start: 1, End: 7}}
+	beat           = protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: &protocol.HeartbeatResponse{DeltaTested: 3, TotalTested: 3, JobStart: 1, LastCompleted: 3}}
+	shutdown       = protocol.Message{Command: protocol.MsgShutdown}
+	fromWorker     = []protocol.Message{ready, beat}
+	fromController = []protocol.Message{job, shutdown}
+)
+
+// playWorker plays the worker's half through enc and dec, sending each of its
+// messages and then reading one answer, and returns the answers
+func playWorker(t *testing.T, enc protocol.Encoder, dec protocol.Decoder) []protocol.Message {
+	t.Helper()
+	var got []protocol.Message
+	for _, msg := range fromWorker {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatalf("send %s: %v", msg.Command, err)
+		}
+		var answer protocol.Message
+		if err := dec.Decode(&answer); err != nil {
+			t.Fatalf("answer to %s: %v", msg.Command, err)
+		}
+		got = append(got, answer)
+	}
+	return got
+}
+
+// recordSession records the worker's side of the session to a file and
+// returns its path
+func recordSession(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "worker.jsonl")
+	rec, err := Create(path, Worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workerEnd, controllerEnd := net.Pipe()
+	defer workerEnd.Close()
+	go func() {
+		defer controllerEnd.Close()
+		enc, dec := json.NewEncoder(controllerEnd), json.NewDecoder(controllerEnd)
+		for _, answer := range fromController {
+			var msg protocol.Message
+			if dec.Decode(&msg) != nil || enc.Encode(answer) != nil {
+				return
+			}
+		}
+	}()
+
+	playWorker(t, rec.Encoder(json.NewEncoder(workerEnd), "controller:1"), rec.Decoder(json.NewDecoder(workerEnd), "controller:1"))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	entries, err := Load(recordSession(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every message is on record, in order, as the worker saw it
+	want := []protocol.Message{ready, job, beat, shutdown}
+	if len(entries) != len(want) {
+		t.Fatalf("recorded %d messages, want %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		direction := Sent
+		if i%2 == 1 {
+			direction = Received
+		}
+		if e.Side != Worker || e.Peer != "controller:1" || e.Direction != direction {
+			t.Errorf("entry %d is %s %s %s, want worker %s controller:1", i, e.Side, e.Direction, e.Peer, direction)
+		}
+		if !reflect.DeepEqual(e.Message, want[i]) {
+			t.Errorf("entry %d is %+v, want %+v", i, e.Message, want[i])
+		}
+	}
+
+	// Played back in place of the controller, the worker gets the same answers
+	workerEnd, controllerEnd := net.Pipe()
+	defer workerEnd.Close()
+	type replayed struct {
+		summary Summary
+		err     error
+	}
+	done := make(chan replayed, 1)
+	go func() {
+		summary, err := Replay(controllerEnd, entries, Worker, "", time.Second, false, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		done <- replayed{summary, err}
+	}()
+
+	got := playWorker(t, json.NewEncoder(workerEnd), json.NewDecoder(workerEnd))
+	if !reflect.DeepEqual(got, fromController) {
+		t.Fatalf("replay sent %+v, want %+v", got, fromController)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.summary != (Summary{Sent: 2, Received: 2}) {
+		t.Fatalf("replay summary %+v, want 2 sent, 2 received and none diverged", r.summary)
+	}
+}
+
+func TestReplayCountsDivergence(t *testing.T) {
+	entries, err := Load(recordSession(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workerEnd, controllerEnd := net.Pipe()
+	defer workerEnd.Close()
+	done := make(chan Summary, 1)
+	go func() {
+		summary, _ := Replay(controllerEnd, entries, Worker, "", 100*time.Millisecond, false, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		done <- summary
+	}()
+
+	// A worker that reports an error where the recording has a heartbeat
+	enc, dec := json.NewEncoder(workerEnd), json.NewDecoder(workerEnd)
+	for _, msg := range []protocol.Message{ready, {Command: protocol.MsgError, Error: "crypt failed"}} {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+		var answer protocol.Message
+		if err := dec.Decode(&answer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if summary := <-done; summary.Diverged != 1 {
+		t.Fatalf("replay summary %+v, want one divergence", summary)
+	}
+}
+
+func TestLoadDamagedRecording(t *testing.T) {
+	data, err := os.ReadFile(recordSession(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	cases := []struct {
+		name string
+		data string
+		line string
+	}{
+		{"truncated", string(data[:len(data)-len(lines[3])/2]), "line 4"},
+		{"corrupt", lines[0] + "{\"time\": nope}\n" + strings.Join(lines[2:], ""), "line 2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "damaged.jsonl")
+			if err := os.WriteFile(path, []byte(tc.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tc.line) {
+				t.Fatalf("Load returned %d entries and %v, want an error at %s", len(entries), err, tc.line)
+			}
+		})
+	}
+
+	// A recording with nothing for the target's peer to say cannot be replayed
+	workerEnd, controllerEnd := net.Pipe()
+	defer workerEnd.Close()
+	if _, err := Replay(controllerEnd, nil, Worker, "", time.Second, false, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Fatal("replaying an empty recording succeeded")
+	}
+}
+
+func TestReplayPacing(t *testing.T) {
+	entries, err := Load(recordSession(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The controller answered the heartbeat a while into the session
+	const gap = 300 * time.Millisecond
+	entries[3].Time = entries[0].Time.Add(gap)
+
+	for _, realTime := range []bool{false, true} {
+		workerEnd, controllerEnd := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := Replay(controllerEnd, entries, Worker, "", time.Second, realTime, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			done <- err
+		}()
+
+		start := time.Now()
+		playWorker(t, json.NewEncoder(workerEnd), json.NewDecoder(workerEnd))
+		elapsed := time.Since(start)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		workerEnd.Close()
+
+		if realTime && elapsed < gap {
+			t.Errorf("real-time replay took %v, want at least %v", elapsed, gap)
+		}
+		if !realTime && elapsed >= gap {
+			t.Errorf("replay took %v, want it not to wait out the recorded %v", elapsed, gap)
+		}
+	}
+}
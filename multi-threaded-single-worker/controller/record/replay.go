@@ -0,0 +1,158 @@
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"cracker/protocol"
+)
+
+// Summary is how a replay went
+type Summary struct {
+	Sent     int
+	Received int
+	Diverged int
+}
+
+// fromPeer reports whether e is a message target's peer sent
+func fromPeer(e Entry, target string) bool {
+	if e.Side == target {
+		return e.Direction == Received
+	}
+	return e.Direction == Sent
+}
+
+// conversation keeps the entries exchanged with one peer, the first one in
+// the recording unless peer names another
+func conversation(entries []Entry, peer string) []Entry {
+	if peer == "" && len(entries) > 0 {
+		peer = entries[0].Peer
+	}
+	var kept []Entry
+	for _, e := range entries {
+		if e.Peer == peer {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Replay stands in for target's peer on conn, target being Controller or
+// Worker. The messages the peer sent in the recording go out in order, each
+// once target has sent as many messages as it had at that point, or after
+// waiting for wait. With realTime they also go out no sooner than they did in
+// the recording, otherwise as fast as target keeps up. Whatever target sends
+// is checked against the recording and divergences are logged. Replay closes
+// conn when it is done.
+func Replay(conn net.Conn, entries []Entry, target, peer string, wait time.Duration, realTime bool, log *slog.Logger) (Summary, error) {
+	defer conn.Close()
+
+	type step struct {
+		msg    protocol.Message
+		after  int
+		offset time.Duration
+	}
+	var steps []step
+	var expected []protocol.Message
+	var first time.Time
+	for _, e := range conversation(entries, peer) {
+		if first.IsZero() {
+			first = e.Time
+		}
+		if fromPeer(e, target) {
+			steps = append(steps, step{msg: e.Message, after: len(expected), offset: e.Time.Sub(first)})
+		} else {
+			expected = append(expected, e.Message)
+		}
+	}
+	if len(steps) == 0 {
+		return Summary{}, fmt.Errorf("recording has no messages to send to the %s", target)
+	}
+
+	var mu sync.Mutex
+	var summary Summary
+	progressed := make(chan struct{}, 1)
+	readerDone := make(chan struct{})
+
+	go func() {
+		defer close(readerDone)
+		decoder := json.NewDecoder(conn)
+		for {
+			var msg protocol.Message
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+			log.Info("<- received", "command", msg.Command)
+
+			mu.Lock()
+			i := summary.Received
+			summary.Received++
+			switch {
+			case i >= len(expected):
+				summary.Diverged++
+				log.Warn("diverged from recording", "position", i, "want", "nothing", "got", msg.Command)
+			case expected[i].Command != msg.Command:
+				summary.Diverged++
+				log.Warn("diverged from recording", "position", i, "want", expected[i].Command, "got", msg.Command)
+			}
+			mu.Unlock()
+
+			select {
+			case progressed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return summary.Received
+	}
+
+	// awaitReceived waits for target to have sent n messages
+	awaitReceived := func(n int) bool {
+		deadline := time.NewTimer(wait)
+		defer deadline.Stop()
+		for received() < n {
+			select {
+			case <-progressed:
+			case <-readerDone:
+				return received() >= n
+			case <-deadline.C:
+				return false
+			}
+		}
+		return true
+	}
+
+	begin := time.Now()
+	encoder := json.NewEncoder(conn)
+	for _, st := range steps {
+		if d := time.Until(begin.Add(st.offset)); realTime && d > 0 {
+			time.Sleep(d)
+		}
+		if !awaitReceived(st.after) {
+			log.Warn("target fell behind the recording", "want", st.after, "got", received())
+		}
+		if err := encoder.Encode(st.msg); err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			return summary, fmt.Errorf("replay %s: %w", st.msg.Command, err)
+		}
+		log.Info("-> replayed", "command", st.msg.Command)
+
+		mu.Lock()
+		summary.Sent++
+		mu.Unlock()
+	}
+	awaitReceived(len(expected))
+
+	conn.Close()
+	<-readerDone
+	return summary, nil
+}
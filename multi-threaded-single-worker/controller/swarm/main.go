@@ -0,0 +1,109 @@
+package swarm
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"cracker/cli"
+	"cracker/logging"
+)
+
+const (
+	synopsis    = "--host HOST --port PORT [--workers N] [--rate N] [--find INDEX] [flags]"
+	description = "Connects many simulated workers to a controller to load test it. They speak the real protocol but only pretend to crack, at --rate candidates a second each, and report the candidate at --find as the password. Runs until the controller shuts them all down, --duration passes or Ctrl-C, then reports the controller's dispatch latency and message throughput."
+)
+
+// Main runs the swarm subcommand with the arguments after its name
+func Main(args []string) {
+	defaults := DefaultConfig()
+	fs := cli.NewFlagSet("swarm", synopsis, description)
+	host := fs.String("host", "", "controller host")
+	port := fs.Int("port", 0, "controller port")
+	workers := fs.Int("workers", defaults.Workers, "number of simulated workers")
+	threads := fs.Int("threads", defaults.Threads, "threads each worker announces")
+	rate := fs.Float64("rate", defaults.Rate, "candidates each worker pretends to test per second")
+	heartbeatEvery := fs.Duration("heartbeat-every", 0, "send heartbeats this often on top of answering the controller's")
+	find := fs.Int64("find", defaults.Find, "keyspace index to report as the password, -1 never")
+	password := fs.String("password", "", "password to report for --find, the candidate at that index by default")
+	ramp := fs.Duration("ramp", 0, "spread the workers' first connections over this long")
+	retry := fs.Duration("retry", defaults.Retry, "wait before reconnecting after a drop or a refusal")
+	idPrefix := fs.String("id-prefix", defaults.IdPrefix, "worker ids are this followed by a number")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until the controller shuts the workers down")
+	var logFlags logging.Flags
+	logFlags.Register(fs)
+
+	if err := cli.Parse(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	log, err := logging.New(os.Stderr, logFlags, "swarm")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *host == "" || *port <= 0 || *port > 65535 || *workers <= 0 || *threads <= 0 || *rate <= 0 || *retry <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg := Config{
+		Workers:        *workers,
+		Threads:        *threads,
+		Rate:           *rate,
+		HeartbeatEvery: *heartbeatEvery,
+		Find:           *find,
+		Password:       *password,
+		Ramp:           *ramp,
+		Retry:          *retry,
+		IdPrefix:       *idPrefix,
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Info("signal received, stopping", "signal", sig)
+		case <-deadline:
+			log.Info("duration reached, stopping", "duration", *duration)
+		}
+		signal.Stop(signals)
+		close(stop)
+	}()
+
+	address := net.JoinHostPort(*host, strconv.Itoa(*port))
+	log.Info("starting swarm", "address", address, "workers", cfg.Workers, "rate", cfg.Rate, "find", cfg.Find)
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout("tcp", address, 5*time.Second)
+	}
+	printReport(Run(cfg, dial, stop, log))
+}
+
+func printReport(r Report) {
+	seconds := r.Elapsed.Seconds()
+	fmt.Printf("Workers:          %d over %s\n", r.Workers, r.Elapsed.Round(time.Millisecond))
+	fmt.Printf("Connections:      %d (%d failed)\n", r.Dials, r.DialErrors)
+	fmt.Printf("Messages:         %d sent, %d received, %.0f/s\n", r.Sent, r.Received, r.MessageRate())
+	fmt.Printf("Heartbeats sent:  %d\n", r.Heartbeats)
+	fmt.Printf("Chunks:           %d handed out, %d exhausted, %d cancelled, %d refusals\n", r.Jobs, r.Chunks, r.Cancelled, r.Rejected)
+	if seconds > 0 {
+		fmt.Printf("Candidates:       %d, %.0f/s\n", r.Tested, float64(r.Tested)/seconds)
+	}
+	if len(r.Dispatch) > 0 {
+		fmt.Printf("Dispatch latency: p50 %s, p90 %s, p99 %s, max %s\n",
+			r.Percentile(0.5), r.Percentile(0.9), r.Percentile(0.99), r.Percentile(1))
+	}
+	if r.FoundBy != "" {
+		fmt.Printf("Found:            %q by %s after %s\n", r.Found, r.FoundBy, r.FoundAfter.Round(time.Millisecond))
+	}
+}
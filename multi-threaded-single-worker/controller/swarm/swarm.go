@@ -0,0 +1,488 @@
+// Package swarm simulates a crowd of workers in one process to load test a
+// controller. The simulated workers speak the real protocol but only pretend
+// to crack: each works through its chunks at a set rate without hashing, and
+// reports a chosen index as the password when it reaches it.
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+// Config is how the simulated workers behave
+type Config struct {
+	Workers int
+	// Threads each worker announces
+	Threads int
+	// Candidates each worker pretends to test per second
+	Rate float64
+	// Send heartbeats this often on top of answering the controller's, 0
+	// only answers
+	HeartbeatEvery time.Duration
+	// Index reported as the password by the worker whose chunk reaches it,
+	// -1 never
+	Find int64
+	// Reported for Find, the candidate at that index by default
+	Password string
+	// Spread the workers' first connections over this long
+	Ramp time.Duration
+	// Wait this long before reconnecting after the connection drops or the
+	// controller has no work
+	Retry time.Duration
+	// Worker ids are this followed by a number
+	IdPrefix string
+}
+
+// DefaultConfig is a hundred four-thread workers testing a million
+// candidates a second each, never finding anything
+func DefaultConfig() Config {
+	return Config{
+		Workers:  100,
+		Threads:  4,
+		Rate:     1e6,
+		Find:     -1,
+		Retry:    time.Second,
+		IdPrefix: "sim",
+	}
+}
+
+// Report is what the swarm saw of the controller
+type Report struct {
+	Workers int
+	Elapsed time.Duration
+
+	// Messages written to and read from the controller
+	Sent     int64
+	Received int64
+
+	Jobs       int64 // chunks handed out
+	Chunks     int64 // chunks finished without a match
+	Cancelled  int64
+	Heartbeats int64 // sent
+	Rejected   int64 // asked for work and got an error
+	Dials      int64
+	DialErrors int64
+	Tested     int64 // candidates the workers pretended to test
+
+	// How long a worker waited for a job after asking for one, sorted
+	Dispatch []time.Duration
+
+	Found      string
+	FoundBy    string
+	FoundAfter time.Duration
+}
+
+// Percentile returns the dispatch wait below which p of them fall, 0 <= p <= 1
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.Dispatch) == 0 {
+		return 0
+	}
+	return r.Dispatch[int(p*float64(len(r.Dispatch)-1))]
+}
+
+// MessageRate is the messages per second exchanged with the controller
+func (r Report) MessageRate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Sent+r.Received) / r.Elapsed.Seconds()
+}
+
+// swarm is the state the simulated workers share
+type swarm struct {
+	cfg   Config
+	dial  func() (net.Conn, error)
+	stop  <-chan struct{}
+	start time.Time
+	log   *slog.Logger
+
+	sent, received, jobs, chunks, cancelled, heartbeats atomic.Int64
+	rejected, dials, dialErrors, tested                 atomic.Int64
+
+	mu         sync.Mutex
+	dispatch   []time.Duration
+	found      string
+	foundBy    string
+	foundAfter time.Duration
+}
+
+// Run starts cfg.Workers simulated workers, each connecting with dial, and
+// returns once the controller has shut every one of them down or stop is
+// closed
+func Run(cfg Config, dial func() (net.Conn, error), stop <-chan struct{}, log *slog.Logger) Report {
+	s := &swarm{cfg: cfg, dial: dial, stop: stop, start: time.Now(), log: log}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		w := &sim{swarm: s, id: fmt.Sprintf("%s-%d", cfg.IdPrefix, i+1)}
+		delay := time.Duration(0)
+		if cfg.Workers > 1 {
+			delay = cfg.Ramp * time.Duration(i) / time.Duration(cfg.Workers-1)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !s.sleep(delay) {
+				return
+			}
+			w.run()
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.dispatch, func(i, j int) bool { return s.dispatch[i] < s.dispatch[j] })
+	return Report{
+		Workers:    cfg.Workers,
+		Elapsed:    time.Since(s.start),
+		Sent:       s.sent.Load(),
+		Received:   s.received.Load(),
+		Jobs:       s.jobs.Load(),
+		Chunks:     s.chunks.Load(),
+		Cancelled:  s.cancelled.Load(),
+		Heartbeats: s.heartbeats.Load(),
+		Rejected:   s.rejected.Load(),
+		Dials:      s.dials.Load(),
+		DialErrors: s.dialErrors.Load(),
+		Tested:     s.tested.Load(),
+		Dispatch:   s.dispatch,
+		Found:      s.found,
+		FoundBy:    s.foundBy,
+		FoundAfter: s.foundAfter,
+	}
+}
+
+// sleep waits for d and reports false if stop was closed first
+func (s *swarm) sleep(d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-s.stop:
+			return false
+		default:
+			return true
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// sim is one simulated worker
+type sim struct {
+	*swarm
+	id string
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu         sync.Mutex
+	job        *protocol.CrackingJob
+	gen        int // bumped per job, so a stale timer does nothing
+	began      time.Time
+	receivedAt time.Time
+	timer      *time.Timer
+	done       int64 // candidates tested on jobs that are over
+	beatDone   int64
+	beatAt     time.Time
+	asked      time.Time // zero unless waiting for a job it asked for
+	resume     *protocol.ResumePoint
+}
+
+// run keeps the worker connected, like a worker daemon, until the
+// controller shuts it down or the swarm stops
+func (w *sim) run() {
+	for {
+		w.dials.Add(1)
+		conn, err := w.dial()
+		if err != nil {
+			w.dialErrors.Add(1)
+			w.log.Debug("dial failed", "worker_id", w.id, "err", err)
+		} else if w.serve(conn) {
+			return
+		}
+		if !w.sleep(w.cfg.Retry) {
+			return
+		}
+	}
+}
+
+// serve runs one connection and reports whether the controller shut the
+// worker down
+func (w *sim) serve(conn net.Conn) bool {
+	defer conn.Close()
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go func() {
+		select {
+		case <-w.stop:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	w.writeMu.Lock()
+	w.enc = json.NewEncoder(conn)
+	w.writeMu.Unlock()
+
+	w.mu.Lock()
+	w.beatAt = time.Now()
+	w.asked = time.Now()
+	hello := &protocol.WorkerHello{WorkerId: w.id, Threads: w.cfg.Threads, Resume: w.resume}
+	w.mu.Unlock()
+	w.send(protocol.Message{Command: protocol.MsgReady, Hello: hello})
+
+	if w.cfg.HeartbeatEvery > 0 {
+		go func() {
+			ticker := time.NewTicker(w.cfg.HeartbeatEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					w.send(protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: w.heartbeat()})
+				case <-closed:
+					return
+				}
+			}
+		}()
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var msg protocol.Message
+		if err := dec.Decode(&msg); err != nil {
+			w.drop()
+			return false
+		}
+		w.received.Add(1)
+
+		switch msg.Command {
+		case protocol.MsgJob:
+			if msg.Job != nil {
+				w.begin(msg.Job)
+			}
+		case protocol.MsgHeartbeat:
+			w.send(protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: w.heartbeat()})
+		case protocol.MsgCancel:
+			if msg.Cancel != nil {
+				w.cancel(msg.Cancel.JobId)
+			}
+		case protocol.MsgShutdown:
+			w.send(protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: w.heartbeat()})
+			w.drop()
+			return true
+		case protocol.MsgError:
+			w.rejected.Add(1)
+			w.drop()
+			return false
+		}
+	}
+}
+
+// send writes msg, dropping it if the connection has failed; the reader
+// notices that soon enough
+func (w *sim) send(msg protocol.Message) {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if err := w.enc.Encode(msg); err != nil {
+		return
+	}
+	w.sent.Add(1)
+	if msg.Command == protocol.MsgHeartbeat {
+		w.heartbeats.Add(1)
+	}
+}
+
+// span is how many candidates of job the worker tests before it is over,
+// and whether it ends on the password; -1 for a job that never ends
+func (w *sim) span(job *protocol.CrackingJob) (int64, bool) {
+	if w.cfg.Find >= job.Start && (job.End == 0 || w.cfg.Find < job.End) {
+		return w.cfg.Find - job.Start + 1, true
+	}
+	if job.End == 0 {
+		return -1, false
+	}
+	return job.End - job.Start, false
+}
+
+// progressLocked is how many candidates of the current job the worker has
+// tested by now
+func (w *sim) progressLocked(now time.Time) int64 {
+	if w.job == nil {
+		return 0
+	}
+	n := int64(now.Sub(w.began).Seconds() * w.cfg.Rate)
+	if span, _ := w.span(w.job); span >= 0 {
+		n = min(n, span)
+	}
+	return n
+}
+
+func (w *sim) begin(job *protocol.CrackingJob) {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopLocked(now)
+	w.jobs.Add(1)
+	if !w.asked.IsZero() {
+		w.swarm.mu.Lock()
+		w.dispatch = append(w.dispatch, now.Sub(w.asked))
+		w.swarm.mu.Unlock()
+		w.asked = time.Time{}
+	}
+
+	w.gen++
+	w.job = job
+	w.began = now
+	w.receivedAt = now
+	if span, _ := w.span(job); span >= 0 && w.cfg.Rate > 0 {
+		gen := w.gen
+		d := time.Duration(float64(span) / w.cfg.Rate * float64(time.Second))
+		w.timer = time.AfterFunc(d, func() { w.finish(gen) })
+	}
+}
+
+// stopLocked ends the current job, counting what was tested of it
+func (w *sim) stopLocked(now time.Time) {
+	if w.job == nil {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	n := w.progressLocked(now)
+	w.done += n
+	w.tested.Add(n)
+	w.job = nil
+}
+
+// finish reports the job of generation gen done, with the password if it
+// ends on it
+func (w *sim) finish(gen int) {
+	now := time.Now()
+	w.mu.Lock()
+	if gen != w.gen || w.job == nil {
+		w.mu.Unlock()
+		return
+	}
+	job := w.job
+	_, found := w.span(job)
+	result := &protocol.CrackResult{
+		JobId:         job.Id,
+		LastCompleted: job.Start + w.progressLocked(now) - 1,
+		Metrics: protocol.WorkerMetrics{
+			TotalCrackingTimeNanos: now.Sub(w.began).Nanoseconds(),
+			WorkerReceiveJobNanos:  w.receivedAt,
+			WorkerSentResultsNanos: now,
+		},
+	}
+	if found {
+		result.Password = w.password(job)
+	}
+	w.stopLocked(now)
+	w.resume = nil
+	w.asked = now
+	w.mu.Unlock()
+
+	if found {
+		w.swarm.mu.Lock()
+		if w.foundBy == "" {
+			w.found, w.foundBy, w.foundAfter = result.Password, w.id, now.Sub(w.start)
+		}
+		w.swarm.mu.Unlock()
+	} else {
+		w.chunks.Add(1)
+	}
+	w.send(protocol.Message{Command: protocol.MsgResult, Result: result})
+}
+
+// password is what the worker reports finding at cfg.Find
+func (w *sim) password(job *protocol.CrackingJob) string {
+	switch {
+	case w.cfg.Password != "":
+		return w.cfg.Password
+	case job.Words != nil:
+		return job.Words[w.cfg.Find-job.Start]
+	case job.Charset != "":
+		return keyspace.Candidate(job.Charset, w.cfg.Find)
+	default:
+		return keyspace.Candidate(keyspace.DefaultCharset, w.cfg.Find)
+	}
+}
+
+func (w *sim) cancel(jobId int) {
+	now := time.Now()
+	w.mu.Lock()
+	job := w.job
+	if job == nil || job.Id != jobId {
+		w.mu.Unlock()
+		return
+	}
+	result := &protocol.CrackResult{
+		JobId:         job.Id,
+		Cancelled:     true,
+		LastCompleted: job.Start + w.progressLocked(now) - 1,
+	}
+	w.stopLocked(now)
+	w.mu.Unlock()
+
+	w.cancelled.Add(1)
+	w.send(protocol.Message{Command: protocol.MsgResult, Result: result})
+}
+
+// drop ends the current job when the connection goes, remembering where to
+// resume it
+func (w *sim) drop() {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.job != nil {
+		w.resume = &protocol.ResumePoint{JobId: w.job.Id, LastCompleted: w.job.Start + w.progressLocked(now) - 1}
+	}
+	w.stopLocked(now)
+}
+
+// heartbeat reports the candidates tested since the last one
+func (w *sim) heartbeat() *protocol.HeartbeatResponse {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	progress := w.progressLocked(now)
+	total := w.done + progress
+	window := now.Sub(w.beatAt)
+	hb := &protocol.HeartbeatResponse{
+		DeltaTested: total - w.beatDone,
+		TotalTested: total,
+		WindowNanos: window.Nanoseconds(),
+	}
+	if window > 0 {
+		hb.CurrentRate = float64(hb.DeltaTested) / window.Seconds()
+	}
+	if w.job != nil {
+		hb.ThreadsActive = int64(w.cfg.Threads)
+		hb.JobStart = w.job.Start
+		hb.LastCompleted = w.job.Start + progress - 1
+	}
+	w.beatDone = total
+	w.beatAt = now
+	return hb
+}
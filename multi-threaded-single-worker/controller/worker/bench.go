@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cracker/crypt"
+	"cracker/keyspace"
+)
+
+// BenchResult is how fast this machine hashed with one setting
+type BenchResult struct {
+	Setting string
+	Threads int
+	Hashes  int64
+	Elapsed time.Duration
+}
+
+// Rate is the hashes per second across all threads
+func (r BenchResult) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Hashes) / r.Elapsed.Seconds()
+}
+
+// Bench hashes candidates with setting on the given number of threads for
+// about d, the way crack does, and reports the rate it reached. It stops
+// early with ctx's error once ctx is done.
+func Bench(ctx context.Context, setting string, threads int, d time.Duration) (BenchResult, error) {
+	counts := make([]int64, threads)
+	errs := make([]error, threads)
+
+	// The threads check a flag rather than the clock or ctx, which would cost
+	// a fast hash more than the hashing
+	timer, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	var stopped atomic.Bool
+	context.AfterFunc(timer, func() { stopped.Store(true) })
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			checker := crypt.NewChecker(setting)
+			defer checker.Close()
+
+			// Threads start at different candidates so libcrypt does no
+			// less work than it would on a real range
+			charset := keyspace.DefaultCharset
+			indices := keyspace.Indices(len(charset), int64(id)*1_000_000)
+			var candidate []byte
+			for !stopped.Load() {
+				candidate = candidate[:0]
+				for _, idx := range indices {
+					candidate = append(candidate, charset[idx])
+				}
+				if _, err := checker.Check(candidate); err != nil {
+					errs[id] = err
+					return
+				}
+				counts[id]++
+				indices = nextPassword(indices, len(charset))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	res := BenchResult{Setting: setting, Threads: threads, Elapsed: time.Since(start)}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	for i := range counts {
+		if errs[i] != nil {
+			return res, errs[i]
+		}
+		res.Hashes += counts[i]
+	}
+	return res, nil
+}
+
+// BenchAlgorithms benchmarks every algorithm crypt can salt for about d each
+// and returns the rates by name, as a worker sends them in its hello. It
+// stops early with ctx's error once ctx is done.
+func BenchAlgorithms(ctx context.Context, threads int, d time.Duration) (map[string]float64, error) {
+	rates := make(map[string]float64, len(crypt.Prefixes))
+	for name := range crypt.Prefixes {
+		setting, err := crypt.Salt(name)
+		if err != nil {
+			return nil, err
+		}
+		res, err := Bench(ctx, setting, threads, d)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rates[name] = res.Rate()
+	}
+	return rates, nil
+}
@@ -1,12 +1,19 @@
-package main
+package worker
 
 import (
-	"encoding/json"
-	"runtime"
-	"sync/atomic"
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
 	"time"
 
-	"controller/protocol"
+	"cracker/protocol"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
 )
 
 type Metrics struct {
@@ -15,59 +22,181 @@ type Metrics struct {
 	ResultReturn time.Duration
 }
 
+// ResultMsg is how crack ended: with the password, an error, or stopped
+// early by the connection ending, the controller cancelling the job or
+// sending another in its place
 type ResultMsg struct {
-	Found string
-	Err   error
+	Found     string
+	Err       error
+	Stopped   bool
+	Cancelled bool
+	Replaced  bool
 }
 
-func writeRequests(encoder *json.Encoder, writeCh <-chan protocol.Message, log *Logger) {
-	for msg := range writeCh {
-		if err := encoder.Encode(msg); err != nil {
-			log.Printf("write error: %v", err)
+// Causes a session's or a job's context is cancelled with
+var (
+	errShutdown  = errors.New("controller shut the worker down")
+	errRejected  = errors.New("controller reported an error")
+	errCancelled = errors.New("job cancelled by the controller")
+	errReplaced  = errors.New("job replaced by another")
+)
+
+// dialWithBackoff keeps trying to reach the controller, doubling the wait
+// between attempts up to maxBackoff, and gives up with nil once ctx is done
+func dialWithBackoff(ctx context.Context, address string, tlsConfig *tls.Config, log *slog.Logger) net.Conn {
+	backoff := minBackoff
+	for {
+		var conn net.Conn
+		var err error
+		if tlsConfig != nil {
+			dialer := tls.Dialer{Config: tlsConfig}
+			conn, err = dialer.DialContext(ctx, "tcp", address)
+		} else {
+			var dialer net.Dialer
+			conn, err = dialer.DialContext(ctx, "tcp", address)
+		}
+		if err == nil {
+			return conn
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Warn("connect error", "err", err, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// send queues msg for the writer, giving up once the connection is over
+func send(ctx context.Context, writeCh chan<- protocol.Message, msg protocol.Message) bool {
+	select {
+	case writeCh <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeRequests keeps draining writeCh after a write error so the reader
+// never blocks on a dead connection; once ctx ends with the reader it writes
+// what is still queued and exits
+func writeRequests(ctx context.Context, encoder protocol.Encoder, writeCh <-chan protocol.Message, log *slog.Logger) {
+	var failed bool
+	write := func(msg protocol.Message) {
+		if failed {
 			return
 		}
+		if err := encoder.Encode(msg); err != nil {
+			log.Warn("write error", "command", msg.Command, "err", err)
+			failed = true
+		}
+	}
+
+	for {
+		select {
+		case msg := <-writeCh:
+			write(msg)
+
+		case <-ctx.Done():
+			for {
+				select {
+				case msg := <-writeCh:
+					write(msg)
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
-func readRequests(decoder *json.Decoder, writeCh chan<- protocol.Message, jobCh chan<- *protocol.CrackingJob, delta_tested *int64, total_tested *int64, log *Logger) {
-	var interval int
+// heartbeat reports the work done since the last one and how far the
+// current job has got
+func heartbeat(stats *telemetry, progress *progressTracker) *protocol.HeartbeatResponse {
+	threads, total, delta, window := stats.sample(time.Now())
+	start, last := progress.snapshot()
+	hb := &protocol.HeartbeatResponse{
+		DeltaTested:   delta,
+		TotalTested:   total,
+		ThreadTested:  threads,
+		ThreadsActive: stats.busy.Load(),
+		Threads:       int(stats.threads.Load()),
+		WindowNanos:   window.Nanoseconds(),
+		JobStart:      start,
+		LastCompleted: last,
+	}
+	if window > 0 {
+		hb.CurrentRate = float64(delta) / window.Seconds()
+	}
+	if cpu, rss, err := processStats(); err == nil {
+		hb.CPUSeconds = cpu
+		hb.RSSBytes = rss
+	}
+	return hb
+}
+
+// readRequests handles the controller's messages until the connection fails
+// or the controller shuts the worker down, and returns why it stopped. beat
+// takes the heartbeats it answers with.
+func readRequests(ctx context.Context, decoder protocol.Decoder, writeCh chan<- protocol.Message, jobCh chan queuedJob, beat func() *protocol.HeartbeatResponse, active *activeJob, log *slog.Logger) error {
 	for {
 		var msg protocol.Message
 		if err := decoder.Decode(&msg); err != nil {
-			log.Printf("decode error: %v", err)
-			close(writeCh)
-			return
+			log.Info("decode error", "err", err)
+			return err
 		}
 
-		log.Printf("<- command %s received", msg.Command)
+		log.Debug("<- command received", "command", msg.Command)
+
+		if err := msg.ValidateFromController(); err != nil {
+			log.Warn("<- invalid controller message", "err", err)
+			continue
+		}
 
 		switch msg.Command {
 
 		case protocol.MsgHeartbeat:
-			delta := atomic.LoadInt64(delta_tested)
-			total := atomic.LoadInt64(total_tested)
-			hb := protocol.HeartbeatResponse{
-				DeltaTested:   delta,
-				TotalTested:   total,
-				ThreadsActive: int64(runtime.NumGoroutine()),
-				CurrentRate:   float64(delta) / float64(interval),
-			}
-			atomic.StoreInt64(delta_tested, 0)
-			log.Println("sending heartbeat ->")
-			hbResponse := protocol.Message{
-				Command:   protocol.MsgHeartbeat,
-				Heartbeat: &hb,
-			}
-			writeCh <- hbResponse
+			log.Debug("-> sending heartbeat", "command", protocol.MsgHeartbeat)
+			send(ctx, writeCh, protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: beat()})
 
 		case protocol.MsgShutdown:
-			return
+			// A last heartbeat tells the controller how far we got, the
+			// writer flushes it before it exits
+			send(ctx, writeCh, protocol.Message{Command: protocol.MsgHeartbeat, Heartbeat: beat()})
+			return errShutdown
+
+		case protocol.MsgError:
+			log.Error("controller error", "err", msg.Error)
+			return errRejected
+
+		case protocol.MsgCancel:
+			if active.stop(msg.Cancel.JobId, errCancelled) {
+				log.Info("<- cancelled job", "job_id", msg.Cancel.JobId)
+			}
 
 		case protocol.MsgJob:
 			job := msg.Job
-			log.Printf("<- received job %d", job.Id)
-			interval = job.Interval
-			jobCh <- job
+			log.Debug("<- received job", "job_id", job.Id)
+
+			// The new job takes over from whatever the worker had, running
+			// or not started yet
+			if active.replace() {
+				log.Info("<- job replaced", "job_id", job.Id)
+			}
+			select {
+			case <-jobCh:
+			default:
+			}
+			select {
+			case jobCh <- active.start(ctx, job):
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
 		}
 	}
 }
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+// FuzzReadRequests feeds the worker's reader whatever a controller might
+// send and checks that it neither panics nor passes on a job the cracking
+// loop cannot start
+func FuzzReadRequests(f *testing.F) {
+	f.Add([]byte(`{"command":"job","job":{"Id":1,"Interval":1,"Charset":"ABC","Start":3,"End":12}}
+{"command":"heartbeat"}
+{"command":"cancel","cancel":{"job_id":1}}
+{"command":"shutdown"}`))
+	f.Add([]byte(`{"command":"job","job":{"Id":2,"Start":0,"End":2,"Words":["a","b"]}}`))
+	f.Add([]byte(`{"command":"job"}{"command":"cancel"}{"command":"heartbeat"}`))
+	f.Add([]byte(`{"command":"job","job":{"Charset":"A","Start":1000000000000}}`))
+	f.Add([]byte(`{"command":"error","error":"no work available"}`))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		writeCh := make(chan protocol.Message, 4)
+		jobCh := make(chan queuedJob, 1)
+		ctx, hangUp := context.WithCancel(context.Background())
+		var active activeJob
+		stats := newTelemetry(2)
+		progress := newProgressTracker()
+
+		jobs := make(chan []*protocol.CrackingJob)
+		go func() {
+			var received []*protocol.CrackingJob
+			for {
+				select {
+				case <-writeCh:
+				case queued := <-jobCh:
+					received = append(received, queued.job)
+				case <-ctx.Done():
+					jobs <- received
+					return
+				}
+			}
+		}()
+
+		readRequests(ctx, json.NewDecoder(bytes.NewReader(data)), writeCh, jobCh, func() *protocol.HeartbeatResponse { return heartbeat(stats, progress) }, &active, log)
+		hangUp()
+
+		for _, job := range <-jobs {
+			if job == nil {
+				t.Fatal("nil job passed on")
+			}
+			if job.Words != nil {
+				continue
+			}
+			charset := job.Charset
+			if charset == "" {
+				charset = keyspace.DefaultCharset
+			}
+			// What crack does first with a brute-force job
+			indices := keyspace.Indices(len(charset), job.Start)
+			nextPassword(indices, len(charset))
+		}
+	})
+}
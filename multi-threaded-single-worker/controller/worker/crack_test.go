@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+func sampleJob(tb testing.TB, shadow string) *protocol.CrackingJob {
+	tb.Helper()
+	job, err := protocol.FindUserInShadow(filepath.Join("..", "..", "passwords", shadow), "aryan")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return job
+}
+
+func TestCrack(t *testing.T) {
+	job := sampleJob(t, "shadow_EAR_md5")
+	job.Charset = "ABCDER"
+	job.Start, job.End = keyspace.Bounds(len(job.Charset), 1, 3)
+
+	for _, threads := range []int{1, 3} {
+		progress := newProgressTracker()
+		progress.reset(job.Id, job.Start)
+		res := crack(context.Background(), job, threads, newTelemetry(threads), progress)
+		if res.Found != "EAR" || res.Err != nil {
+			t.Fatalf("%d threads: got %+v, want EAR", threads, res)
+		}
+	}
+
+	// Without the password in range every candidate is tested once
+	job.End = keyspace.Offset(len(job.Charset), 3)
+	stats := newTelemetry(2)
+	progress := newProgressTracker()
+	progress.reset(job.Id, job.Start)
+	res := crack(context.Background(), job, 2, stats, progress)
+	if res != (ResultMsg{}) {
+		t.Fatalf("got %+v, want the range exhausted", res)
+	}
+	if _, total, _, _ := stats.sample(stats.lastAt); total != job.End-job.Start {
+		t.Fatalf("tested %d candidates, want %d", total, job.End-job.Start)
+	}
+	if last := progress.lastCompleted(); last != job.End-1 {
+		t.Fatalf("watermark at %d, want %d", last, job.End-1)
+	}
+}
+
+func TestCrackLastCandidateOfPartialBatch(t *testing.T) {
+	job := sampleJob(t, "shadow_EAR_md5")
+	job.Charset = "ABCDER"
+	ear := keyspace.Index(len(job.Charset), []int{4, 0, 5})
+	if got := keyspace.Candidate(job.Charset, ear); got != "EAR" {
+		t.Fatalf("candidate %d is %q, want EAR", ear, got)
+	}
+
+	// EAR ends a range of two full batches and a short one
+	job.End = ear + 1
+	job.Start = job.End - 2*batchSize - 5
+	for _, threads := range []int{1, 2, 3} {
+		progress := newProgressTracker()
+		progress.reset(job.Id, job.Start)
+		res := crack(context.Background(), job, threads, newTelemetry(threads), progress)
+		if res.Found != "EAR" || res.Err != nil {
+			t.Fatalf("%d threads: got %+v, want EAR", threads, res)
+		}
+	}
+}
+
+func TestCrackWords(t *testing.T) {
+	job := sampleJob(t, "shadow_CAB_sha256")
+	job.Words = []string{"ACE", "BAD", "CAB", "DAD"}
+	job.Start, job.End = 10, 14
+
+	res := crack(context.Background(), job, 2, newTelemetry(2), newProgressTracker())
+	if res.Found != "CAB" {
+		t.Fatalf("got %+v, want CAB", res)
+	}
+}
+
+func TestProgressOutOfOrder(t *testing.T) {
+	p := newProgressTracker()
+	p.reset(1, 100)
+	p.completeRange(164, 228)
+	p.completeRange(228, 250)
+	if last := p.lastCompleted(); last != 99 {
+		t.Fatalf("watermark %d before the first batch is in, want 99", last)
+	}
+	p.completeRange(100, 164)
+	if last := p.lastCompleted(); last != 249 {
+		t.Fatalf("watermark %d, want 249", last)
+	}
+}
+
+// BenchmarkCrackMD5 tests b.N candidates without a match, the cost of a
+// candidate with a fast hash
+func BenchmarkCrackMD5(b *testing.B) {
+	job := sampleJob(b, "shadow_ACE_md5")
+	charset := keyspace.DefaultCharset
+	counts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		counts = append(counts, n)
+	}
+	for _, threads := range counts {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			job.Start = keyspace.Offset(len(charset), 5)
+			job.End = job.Start + int64(b.N)
+			b.ReportAllocs()
+			b.ResetTimer()
+			crack(context.Background(), job, threads, newTelemetry(threads), newProgressTracker())
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "candidates/s")
+		})
+	}
+}
+
+// BenchmarkDispatch compares getting b.N candidates to the cracking threads
+// without hashing them: one channel send and string per candidate, the way
+// crack used to, against batches built in place
+func BenchmarkDispatch(b *testing.B) {
+	charset := keyspace.DefaultCharset
+	start := keyspace.Offset(len(charset), 5)
+	threads := runtime.NumCPU()
+
+	b.Run("channel", func(b *testing.B) {
+		b.ReportAllocs()
+		jobs := make(chan string, threads)
+		var wg sync.WaitGroup
+		var sink atomic.Int64
+		for i := 0; i < threads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := range jobs {
+					sink.Add(int64(len(c)))
+				}
+			}()
+		}
+		indices := keyspace.Indices(len(charset), start)
+		for n := 0; n < b.N; n++ {
+			buf := make([]byte, len(indices))
+			for i, idx := range indices {
+				buf[i] = charset[idx]
+			}
+			jobs <- string(buf)
+			indices = nextPassword(indices, len(charset))
+		}
+		close(jobs)
+		wg.Wait()
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		work := newBatches(start, start+int64(b.N))
+		var wg sync.WaitGroup
+		var sink atomic.Int64
+		for i := 0; i < threads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var candidate []byte
+				var sum int64
+				for {
+					lo, hi, ok := work.claim()
+					if !ok {
+						break
+					}
+					indices := keyspace.Indices(len(charset), lo)
+					for index := lo; index < hi; index++ {
+						candidate = candidate[:0]
+						for _, idx := range indices {
+							candidate = append(candidate, charset[idx])
+						}
+						sum += int64(len(candidate))
+						indices = nextPassword(indices, len(charset))
+					}
+				}
+				sink.Add(sum)
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,244 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"cracker/keyspace"
+	"cracker/protocol"
+)
+
+// fakeController is the controller's end of a net.Pipe to a worker
+// running Serve
+type fakeController struct {
+	t       *testing.T
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	served  chan bool
+}
+
+func serveFake(t *testing.T, ctx context.Context) *fakeController {
+	t.Helper()
+	workerEnd, controllerEnd := net.Pipe()
+	c := &fakeController{
+		t:       t,
+		conn:    controllerEnd,
+		encoder: json.NewEncoder(controllerEnd),
+		decoder: json.NewDecoder(controllerEnd),
+		served:  make(chan bool, 1),
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	go func() {
+		c.served <- Serve(ctx, workerEnd, protocol.WorkerHello{WorkerId: "w", Threads: 2}, 2, log)
+		workerEnd.Close()
+	}()
+	c.expect(protocol.MsgReady)
+	return c
+}
+
+func (c *fakeController) send(msg protocol.Message) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := c.encoder.Encode(msg); err != nil {
+		c.t.Fatalf("send %s: %v", msg.Command, err)
+	}
+}
+
+// expect reads the worker's next message and checks its command
+func (c *fakeController) expect(command protocol.Command) protocol.Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg protocol.Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		c.t.Fatalf("waiting for %s: %v", command, err)
+	}
+	if msg.Command != command {
+		c.t.Fatalf("got %s, want %s", msg.Command, command)
+	}
+	return msg
+}
+
+// cracking asks for heartbeats until the worker reports progress on the job
+// starting at start
+func (c *fakeController) cracking(start int64) {
+	c.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		c.send(protocol.Message{Command: protocol.MsgHeartbeat})
+		hb := c.expect(protocol.MsgHeartbeat).Heartbeat
+		var tested int64
+		for _, n := range hb.ThreadTested {
+			tested += n
+		}
+		if hb.JobStart == start && tested > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("worker never started on the job at %d", start)
+}
+
+// shutdown sends a shutdown, which the worker answers with a last heartbeat
+func (c *fakeController) shutdown() {
+	c.t.Helper()
+	c.send(protocol.Message{Command: protocol.MsgShutdown})
+	c.expect(protocol.MsgHeartbeat)
+}
+
+// wait waits for Serve to return and checks what it reported. The encoder
+// may leave the tail of the last message in the pipe, so what the worker still
+// writes is drained meanwhile.
+func (c *fakeController) wait(shutdown bool) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Time{})
+	go io.Copy(io.Discard, c.conn)
+	select {
+	case got := <-c.served:
+		if got != shutdown {
+			c.t.Fatalf("Serve returned %v, want %v", got, shutdown)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("Serve did not return")
+	}
+	c.conn.Close()
+}
+
+// longJob is an md5 job whose range takes far longer than any test to search
+func longJob(t *testing.T, id int, start int64) *protocol.CrackingJob {
+	job := sampleJob(t, "shadow_EAR_md5")
+	job.Id = id
+	job.Charset = "ABC"
+	job.Start = start
+	job.End = keyspace.Offset(len(job.Charset), 20)
+	return job
+}
+
+// noLeaks checks that every goroutine started since baseline has exited
+func noLeaks(t *testing.T, baseline int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	t.Fatalf("%d goroutines left, want %d:\n%s", runtime.NumGoroutine(), baseline, buf[:n])
+}
+
+func TestLifecycle(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, c *fakeController, leave context.CancelFunc)
+	}{
+		{"shutdown mid-job", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.cracking(0)
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"cancel then shutdown", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.cracking(0)
+			c.send(protocol.Message{Command: protocol.MsgCancel, Cancel: &protocol.CancelRequest{JobId: 1}})
+			if res := c.expect(protocol.MsgResult).Result; !res.Cancelled || res.JobId != 1 {
+				t.Fatalf("got %+v, want job 1 cancelled", res)
+			}
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"cancel right after the job", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.send(protocol.Message{Command: protocol.MsgCancel, Cancel: &protocol.CancelRequest{JobId: 1}})
+			if res := c.expect(protocol.MsgResult).Result; !res.Cancelled || res.JobId != 1 {
+				t.Fatalf("got %+v, want job 1 cancelled", res)
+			}
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"job replaced before it starts", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 2, 1000)})
+			c.cracking(1000)
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"job replaced", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.cracking(0)
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 2, 1000)})
+			c.cracking(1000)
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"leave", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.cracking(0)
+			leave()
+			if hb := c.expect(protocol.MsgLeave).Heartbeat; hb == nil || hb.JobStart != 0 {
+				t.Fatalf("leave carried %+v, want the job's progress", hb)
+			}
+			c.shutdown()
+			c.wait(true)
+		}},
+		{"connection dropped", func(t *testing.T, c *fakeController, leave context.CancelFunc) {
+			c.send(protocol.Message{Command: protocol.MsgJob, Job: longJob(t, 1, 0)})
+			c.cracking(0)
+			c.conn.Close()
+			c.wait(false)
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseline := runtime.NumGoroutine()
+			ctx, leave := context.WithCancel(context.Background())
+			tc.run(t, serveFake(t, ctx), leave)
+			leave()
+			noLeaks(t, baseline)
+		})
+	}
+}
+
+func TestRunBacksOffWhenRejected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A controller with no work left turns every worker away
+	dials := make(chan struct{}, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials <- struct{}{}
+			var ready protocol.Message
+			json.NewDecoder(conn).Decode(&ready)
+			json.NewEncoder(conn).Encode(protocol.Message{Command: protocol.MsgError, Error: "no work available"})
+			conn.Close()
+		}
+	}()
+
+	// Waiting minBackoff and then twice that leaves room for two dials,
+	// redialing at once would make thousands
+	window := 2*minBackoff + minBackoff/2
+	ctx, cancel := context.WithTimeout(context.Background(), window)
+	defer cancel()
+	if err := Run(ctx, Config{Address: ln.Addr().String(), Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dials); n < 2 || n > 3 {
+		t.Fatalf("%d dials in %v, want 2 or 3", n, window)
+	}
+}
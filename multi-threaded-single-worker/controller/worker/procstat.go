@@ -0,0 +1,56 @@
+package worker
+
+/*
+#include <unistd.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processStats reads the CPU time and resident memory of this process from
+// /proc/self. It fails on systems without procfs.
+func processStats() (cpuSeconds float64, rssBytes int64, err error) {
+	stat, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The command name may hold spaces, so count fields from after it
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, 0, fmt.Errorf("malformed /proc/self/stat")
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return 0, 0, fmt.Errorf("malformed /proc/self/stat")
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("utime: %w", err)
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stime: %w", err)
+	}
+	ticks := float64(C.sysconf(C._SC_CLK_TCK))
+	cpuSeconds = float64(utime+stime) / ticks
+
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, err
+	}
+	pages := strings.Fields(string(statm))
+	if len(pages) < 2 {
+		return 0, 0, fmt.Errorf("malformed /proc/self/statm")
+	}
+	resident, err := strconv.ParseInt(pages[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("resident pages: %w", err)
+	}
+	return cpuSeconds, resident * int64(os.Getpagesize()), nil
+}
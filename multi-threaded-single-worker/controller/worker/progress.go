@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"context"
+	"sync"
+
+	"cracker/protocol"
+)
+
+// progressTracker keeps a low watermark over the candidates of a job: every
+// index up to and including last has been tested. Threads finish batches out
+// of order, so a batch above the watermark waits in pending, by its first
+// index, until the gap below it closes.
+type progressTracker struct {
+	mu      sync.Mutex
+	jobId   int
+	start   int64
+	last    int64
+	pending map[int64]int64
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{pending: make(map[int64]int64)}
+}
+
+// reset starts tracking a job whose first untested candidate is start and
+// reports whether it is a different job from the last one
+func (p *progressTracker) reset(jobId int, start int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := jobId != p.jobId
+	p.jobId = jobId
+	p.start = start
+	p.last = start - 1
+	clear(p.pending)
+	return changed
+}
+
+// completeRange marks the indices [lo, hi) tested
+func (p *progressTracker) completeRange(lo int64, hi int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if lo != p.last+1 {
+		p.pending[lo] = hi
+		return
+	}
+
+	p.last = hi - 1
+	for {
+		hi, ok := p.pending[p.last+1]
+		if !ok {
+			return
+		}
+		delete(p.pending, p.last+1)
+		p.last = hi - 1
+	}
+}
+
+func (p *progressTracker) lastCompleted() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.last
+}
+
+// snapshot returns the start of the tracked job with its watermark
+func (p *progressTracker) snapshot() (start int64, last int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.start, p.last
+}
+
+// activeJob lets the reader cancel the job the cracking threads are on, or
+// are about to start, without touching the connection
+type activeJob struct {
+	mu     sync.Mutex
+	id     int
+	run    int
+	cancel context.CancelCauseFunc
+}
+
+// queuedJob is a job the reader has registered and handed to the cracking
+// loop, with the context to run it under
+type queuedJob struct {
+	job *protocol.CrackingJob
+	ctx context.Context
+	run int
+}
+
+// start registers job as the current one, before it is handed to the
+// cracking loop, so a cancel or another job right behind it finds it
+func (a *activeJob) start(ctx context.Context, job *protocol.CrackingJob) queuedJob {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ctx, a.cancel = context.WithCancelCause(ctx)
+	a.id = job.Id
+	a.run++
+	return queuedJob{job: job, ctx: ctx, run: a.run}
+}
+
+// stop cancels the job with cause if job id is the one running
+func (a *activeJob) stop(id int, cause error) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cancel == nil || a.id != id {
+		return false
+	}
+	a.cancel(cause)
+	a.cancel = nil
+	return true
+}
+
+// replace stops the running job, whichever it is, for another to take over
+func (a *activeJob) replace() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cancel == nil {
+		return false
+	}
+	a.cancel(errReplaced)
+	a.cancel = nil
+	return true
+}
+
+// finish marks the job started as run as over, unless another has been
+// started since
+func (a *activeJob) finish(run int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cancel != nil && a.run == run {
+		a.cancel(nil)
+		a.cancel = nil
+	}
+}
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"cracker/crypt"
+	"cracker/protocol"
+	"cracker/record"
+)
+
+// Config describes a worker for Run
+type Config struct {
+	// Address is the controller's host:port
+	Address string
+	// TLS reaches the controller over TLS when set
+	TLS *tls.Config
+	// WorkerId names the worker to the controller across reconnects, the
+	// host name and process id by default
+	WorkerId string
+	// Threads is the number of cracking threads, or with AutoThreads the
+	// most that calibrating each hash may pick
+	Threads     int
+	AutoThreads bool
+	// Bench benchmarks every algorithm for this long before connecting and
+	// tells the controller, so its first chunks fit
+	Bench time.Duration
+	// Logger receives the worker's log lines, nil discards them
+	Logger *slog.Logger
+	Events Events
+}
+
+// Events lets a program running a worker follow along. Either may be nil.
+// They are called from the worker's own goroutines and should return quickly.
+type Events struct {
+	// Progress is called with every heartbeat the worker sends
+	Progress func(hb protocol.HeartbeatResponse)
+	// Result is called as each job is over, unless the connection dropped or
+	// another job took its place first
+	Result func(r Result)
+}
+
+// Result is how a job the controller handed out ended
+type Result struct {
+	Job       protocol.CrackingJob
+	Password  string
+	Found     bool
+	Cancelled bool
+	Err       error
+	Elapsed   time.Duration
+}
+
+// runOptions are the command line's debugging aids, which Run leaves out
+type runOptions struct {
+	rec            *record.Recorder
+	replay         []record.Entry
+	replayFile     string
+	replayPeer     string
+	replayRealTime bool
+}
+
+// Run connects to the controller at cfg.Address and cracks the chunks it
+// hands out, reconnecting whenever the connection drops, until the
+// controller shuts the worker down. Cancelling ctx hands the current range
+// back to the controller and returns once it lets the worker go.
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.Address == "" {
+		return errors.New("no controller address")
+	}
+	return run(ctx, cfg, runOptions{})
+}
+
+func run(ctx context.Context, cfg Config, opts runOptions) error {
+	if cfg.Threads <= 0 || cfg.Threads > protocol.MaxThreads {
+		return fmt.Errorf("%d threads is not between 1 and %d", cfg.Threads, protocol.MaxThreads)
+	}
+	if cfg.WorkerId == "" {
+		cfg.WorkerId = defaultWorkerId()
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	log = log.With("worker_id", cfg.WorkerId)
+
+	var tuner *threadTuner
+	if cfg.AutoThreads {
+		tuner = newThreadTuner(cfg.Threads)
+	}
+	stats := newTelemetry(cfg.Threads)
+	progress := newProgressTracker()
+
+	hello := protocol.WorkerHello{WorkerId: cfg.WorkerId, Threads: cfg.Threads}
+	if cfg.Bench > 0 {
+		log.Info("benchmarking", "algorithms", len(crypt.Prefixes), "each_for", cfg.Bench)
+		var err error
+		if hello.Bench, err = BenchAlgorithms(ctx, cfg.Threads, cfg.Bench); err != nil {
+			if ctx.Err() != nil {
+				log.Info("left while benchmarking")
+				return nil
+			}
+			return fmt.Errorf("benchmark: %w", err)
+		}
+		log.Info("benchmark done", "rates", hello.Bench)
+	}
+
+	// A replay runs a single session against the recording, with no network
+	if opts.replay != nil {
+		workerEnd, controllerEnd := net.Pipe()
+		go func() {
+			summary, err := record.Replay(controllerEnd, opts.replay, record.Worker, opts.replayPeer, 5*time.Second, opts.replayRealTime, log.With("replay", opts.replayFile))
+			if err != nil {
+				log.Error("replay failed", "err", err)
+			}
+			log.Info("replay finished", "sent", summary.Sent, "received", summary.Received, "diverged", summary.Diverged)
+		}()
+
+		end := runSession(ctx, workerEnd, &hello, cfg.Threads, tuner, stats, progress, opts.rec, cfg.Events, log)
+		workerEnd.Close()
+		log.Info("replay session over", "shutdown", end.shutdown)
+		return nil
+	}
+
+	// Run as a daemon: stay connected to the controller, reconnecting
+	// whenever the connection drops, until it explicitly shuts us down.
+	// A controller that turns the worker away, or hangs up before handing
+	// out a job, is retried with the same doubling wait as a failed dial.
+	backoff := minBackoff
+	for {
+		conn := dialWithBackoff(ctx, cfg.Address, cfg.TLS, log)
+		if conn == nil {
+			log.Info("left before reaching the controller")
+			return nil
+		}
+		log.Info("connected to controller", "address", cfg.Address, "tls", cfg.TLS != nil)
+
+		end := runSession(ctx, conn, &hello, cfg.Threads, tuner, stats, progress, opts.rec, cfg.Events, log)
+		conn.Close()
+		if end.shutdown {
+			log.Info("shutdown received, exiting")
+			return nil
+		}
+		if ctx.Err() != nil {
+			log.Info("left the controller")
+			return nil
+		}
+
+		if end.rejected || end.jobs == 0 {
+			log.Warn("no job from the controller", "rejected", end.rejected, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				log.Info("left before getting a job")
+				return nil
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+
+		if hello.Resume != nil {
+			log.Warn("connection lost, resuming", "job_id", hello.Resume.JobId, "last_completed", hello.Resume.LastCompleted)
+		} else {
+			log.Warn("connection lost, reconnecting")
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Size of a CPU cache line, which counter pads to
+const cacheLine = 64
+
+// counter is one thread's count, padded out to a cache line of its own so
+// threads counting side by side never contend for one
+type counter struct {
+	atomic.Int64
+	_ [cacheLine - 8]byte
+}
+
+// telemetry is what the cracking threads report through heartbeats. Every
+// thread counts into its own counter and only ever adds to it; the reader
+// adds the counters up when a heartbeat comes in and takes the difference
+// from the previous sum, so nothing counted between heartbeats is lost.
+// The per-thread counts are for the current job, the total runs on across
+// jobs.
+type telemetry struct {
+	tested []counter
+	busy   atomic.Int64
+	// Threads the current job runs on, fewer than tested has slots when the
+	// count was tuned
+	threads atomic.Int64
+
+	// Where the last sample left off, sampled by the reader and once more
+	// by a worker that is leaving, and what earlier jobs tested
+	mu        sync.Mutex
+	carried   int64
+	lastTotal int64
+	lastAt    time.Time
+}
+
+func newTelemetry(threads int) *telemetry {
+	t := &telemetry{
+		tested: make([]counter, threads),
+		lastAt: time.Now(),
+	}
+	t.threads.Store(int64(threads))
+	return t
+}
+
+// reset starts the per-thread counts over for a new job, between jobs when
+// no thread is counting. They are carried into the total, so what the last
+// job tested since the previous sample shows up in the next delta.
+func (t *telemetry) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.tested {
+		t.carried += t.tested[i].Swap(0)
+	}
+}
+
+// sample reads the per-thread counts and returns them with the total tested
+// on every job, the candidates tested since the last sample and the time that
+// took
+func (t *telemetry) sample(now time.Time) (threads []int64, total int64, delta int64, window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	threads = make([]int64, len(t.tested))
+	total = t.carried
+	for i := range t.tested {
+		threads[i] = t.tested[i].Load()
+		total += threads[i]
+	}
+	delta = total - t.lastTotal
+	window = now.Sub(t.lastAt)
+
+	t.lastTotal = total
+	t.lastAt = now
+	return threads, total, delta, window
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTelemetryLosesNothingBetweenSamples(t *testing.T) {
+	const threads, perThread = 4, 10000
+	stats := newTelemetry(threads)
+
+	var wg sync.WaitGroup
+	var sampled atomic.Int64
+	stop := make(chan struct{})
+	sampler := make(chan struct{})
+	go func() {
+		defer close(sampler)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, _, delta, _ := stats.sample(time.Now())
+			if delta < 0 {
+				t.Errorf("negative delta %d", delta)
+			}
+			sampled.Add(delta)
+		}
+	}()
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for n := 0; n < perThread; n++ {
+				stats.tested[id].Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-sampler
+
+	_, total, delta, _ := stats.sample(time.Now())
+	if got := sampled.Load() + delta; got != threads*perThread || total != threads*perThread {
+		t.Fatalf("deltas add up to %d and the total is %d, want %d", got, total, threads*perThread)
+	}
+
+	// A new job starts the per-thread counts over and keeps the total
+	stats.reset()
+	stats.tested[0].Add(3)
+	threadCounts, total, delta, _ := stats.sample(time.Now())
+	if threadCounts[0] != 3 || total != threads*perThread+3 || delta != 3 {
+		t.Fatalf("after reset got thread 0 at %d, total %d, delta %d, want 3, %d and 3", threadCounts[0], total, delta, threads*perThread+3)
+	}
+}
+
+func TestTelemetryCarriesCountsAcrossJobs(t *testing.T) {
+	stats := newTelemetry(2)
+	progress := newProgressTracker()
+	stats.tested[0].Add(5)
+	heartbeat(stats, progress)
+
+	// Tested by the last job after its final heartbeat, just before the next
+	stats.tested[0].Add(2)
+	stats.tested[1].Add(4)
+	stats.reset()
+	stats.tested[1].Add(1)
+
+	hb := heartbeat(stats, progress)
+	if hb.DeltaTested != 7 || hb.TotalTested != 12 {
+		t.Fatalf("got delta %d and total %d, want 7 and 12", hb.DeltaTested, hb.TotalTested)
+	}
+	if hb.ThreadTested[0] != 0 || hb.ThreadTested[1] != 1 {
+		t.Fatalf("got thread counts %v, want the new job's [0 1]", hb.ThreadTested)
+	}
+}
+
+// BenchmarkCounters counts from every CPU at once into counters side by
+// side, packed into shared cache lines against padded apart
+func BenchmarkCounters(b *testing.B) {
+	b.Run("packed", func(b *testing.B) {
+		counts := make([]atomic.Int64, 64)
+		var next atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			c := &counts[next.Add(1)%64]
+			for pb.Next() {
+				c.Add(1)
+			}
+		})
+	})
+	b.Run("padded", func(b *testing.B) {
+		counts := make([]counter, 64)
+		var next atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			c := &counts[next.Add(1)%64]
+			for pb.Next() {
+				c.Add(1)
+			}
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cracker/protocol"
+)
+
+// How long each thread count is tried for when calibrating
+const calibrationStep = 500 * time.Millisecond
+
+// A smaller thread count has to beat the best rate so far by this much to be
+// picked, so noise does not cost a fast hash its threads
+const calibrationMargin = 1.05
+
+// threadTuner picks the thread count for --threads auto. Memory-hard hashes
+// like yescrypt can run faster on fewer threads than the machine has cores,
+// so each hash is calibrated once, the first time a job for it arrives.
+type threadTuner struct {
+	max    int
+	chosen map[string]int // by the job's full hash
+}
+
+func newThreadTuner(max int) *threadTuner {
+	return &threadTuner{max: max, chosen: make(map[string]int)}
+}
+
+// threadsFor returns the thread count with the best rate on job's hash,
+// starting at max and halving while that helps
+func (t *threadTuner) threadsFor(ctx context.Context, job *protocol.CrackingJob, log *slog.Logger) int {
+	if n, ok := t.chosen[job.FullHash]; ok {
+		return n
+	}
+
+	best, bestRate := t.max, 0.0
+	for n := t.max; n >= 1 && ctx.Err() == nil; n /= 2 {
+		res, err := Bench(ctx, job.FullHash, n, calibrationStep)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			// crack runs into the same error and reports it
+			log.Warn("calibration failed", "threads", n, "err", err)
+			return t.max
+		}
+		log.Debug("calibrating", "threads", n, "rate", res.Rate())
+		if n != t.max && res.Rate() <= bestRate*calibrationMargin {
+			break
+		}
+		best, bestRate = n, res.Rate()
+	}
+
+	if ctx.Err() != nil {
+		// The job is over before calibrating finished, try again next time
+		return best
+	}
+	log.Info("calibrated thread count", "algorithm", protocol.Algorithm(job.Setting), "threads", best, "rate", bestRate)
+	t.chosen[job.FullHash] = best
+	return best
+}
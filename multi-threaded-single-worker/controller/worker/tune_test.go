@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestParseThreads(t *testing.T) {
+	if n, auto, err := parseThreads("3"); n != 3 || auto || err != nil {
+		t.Errorf("3: got %d, %v, %v", n, auto, err)
+	}
+	if n, auto, err := parseThreads("auto"); n <= 0 || !auto || err != nil {
+		t.Errorf("auto: got %d, %v, %v", n, auto, err)
+	}
+	for _, value := range []string{"0", "-2", "many", "100000"} {
+		if _, _, err := parseThreads(value); err == nil {
+			t.Errorf("%q parsed", value)
+		}
+	}
+}
+
+func TestThreadTunerCalibratesOncePerHash(t *testing.T) {
+	job := sampleJob(t, "shadow_ACE_md5")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tuner := newThreadTuner(2)
+	n := tuner.threadsFor(context.Background(), job, log)
+	if n < 1 || n > 2 {
+		t.Fatalf("picked %d threads, want 1 or 2", n)
+	}
+	if again := tuner.threadsFor(context.Background(), job, log); again != n || len(tuner.chosen) != 1 {
+		t.Fatalf("second pick %d after %d, %d hashes calibrated", again, n, len(tuner.chosen))
+	}
+}
+
+func TestCalibrationStopsWithTheJob(t *testing.T) {
+	job := sampleJob(t, "shadow_ACE_md5")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The job is cancelled a moment into the first calibration step
+	ctx, cancel := context.WithTimeout(context.Background(), calibrationStep/10)
+	defer cancel()
+	tuner := newThreadTuner(2)
+	start := time.Now()
+	if n := tuner.threadsFor(ctx, job, log); n != 2 {
+		t.Fatalf("picked %d threads, want the most, 2", n)
+	}
+	if elapsed := time.Since(start); elapsed >= calibrationStep {
+		t.Fatalf("calibrating took %v after the job was cancelled", elapsed)
+	}
+	if len(tuner.chosen) != 0 {
+		t.Fatal("an interrupted calibration was kept")
+	}
+
+	if _, err := Bench(ctx, job.FullHash, 1, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Bench with a finished context returned %v", err)
+	}
+}
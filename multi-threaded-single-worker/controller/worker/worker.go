@@ -1,208 +1,516 @@
-package main
-
-/*
-#cgo LDFLAGS: -lcrypt
-#include <stdlib.h>
-#include <crypt.h>
-*/
-import "C"
+package worker
 
 import (
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
-	"unsafe"
 
-	"controller/protocol"
+	"cracker/cli"
+	"cracker/crypt"
+	"cracker/keyspace"
+	"cracker/logging"
+	"cracker/protocol"
+	"cracker/record"
 )
 
-type Logger struct {
-	*log.Logger
-}
+// How long a leaving worker waits for the controller to let it go
+const leaveTimeout = 5 * time.Second
+
+const (
+	synopsis    = "(--host HOST --port PORT | --replay RECORDING) --threads N|auto [flags]"
+	description = "Connects to a controller and cracks the chunks it hands out, reconnecting whenever the connection drops until the controller shuts it down."
+)
 
-func NewLogger(prefix string) *Logger {
-	return &Logger{
-		log.New(os.Stdout, prefix+" ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile),
+// Main runs the worker subcommand with the arguments after its name
+func Main(args []string) {
+	// Parse arguments
+	fs := cli.NewFlagSet("worker", synopsis, description)
+	threadsFlag := fs.String("threads", "", "number of cracking threads, or auto to calibrate against each job's hash starting from the number of CPUs")
+	host := fs.String("host", "", "controller host")
+	port := fs.Int("port", 0, "controller port")
+	workerId := fs.String("id", defaultWorkerId(), "worker id, kept across reconnects")
+	recordFile := fs.String("record", "", "record every message exchanged with the controller to this JSONL file")
+	replayFile := fs.String("replay", "", "play the controller side of a recording into the worker instead of connecting")
+	replayPeer := fs.String("replay-peer", "", "connection in the recording to replay, the first one by default")
+	replayRealTime := fs.Bool("replay-realtime", false, "replay messages no faster than they were recorded instead of as fast as the worker keeps up")
+	useTLS := fs.Bool("tls", false, "connect to the controller over TLS, trusting the system CAs unless --tls-ca is given")
+	tlsCA := fs.String("tls-ca", "", "CA to verify the controller's certificate with, implies --tls")
+	tlsCert := fs.String("tls-cert", "", "certificate to present to the controller, implies --tls")
+	tlsKey := fs.String("tls-key", "", "private key for --tls-cert")
+	tlsServerName := fs.String("tls-server-name", "", "name to expect in the controller's certificate, --host by default")
+	benchFor := fs.Duration("bench", 0, "benchmark each algorithm for this long before connecting and tell the controller, so the first chunks fit")
+	var logFlags logging.Flags
+	logFlags.Register(fs)
+
+	if err := cli.Parse(fs, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	log, err := logging.New(os.Stdout, logFlags, "worker")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	threads, auto, err := parseThreads(*threadsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--threads:", err)
+		os.Exit(2)
+	}
+	if (*replayFile == "" && (*host == "" || *port <= 0 || *port > 65535)) || threads <= 0 || *workerId == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *tlsServerName == "" {
+		*tlsServerName = *host
+	}
+	tlsConfig, err := clientTLS(*useTLS, *tlsCA, *tlsCert, *tlsKey, *tlsServerName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-}
 
-var charset = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ" + "abcdefghijklmnopqrstuvwxyz" + "0123456789" + "@#%^&*()_+-=.,:;?")
+	var rec *record.Recorder
+	if *recordFile != "" {
+		rec, err = record.Create(*recordFile, record.Worker)
+		if err != nil {
+			log.Error("failed to start recording", "err", err)
+			os.Exit(1)
+		}
+		defer rec.Close()
+	}
 
-func main() {
+	opts := runOptions{rec: rec, replayFile: *replayFile, replayPeer: *replayPeer, replayRealTime: *replayRealTime}
+	if *replayFile != "" {
+		opts.replay, err = record.Load(*replayFile)
+		if err != nil {
+			log.Error("failed to load recording", "err", err)
+			os.Exit(1)
+		}
+	}
 
-	// Parse arguments
-	log := NewLogger("[Worker]")
-	threads := flag.Int("t", 0, "number of threads")
-	host := flag.String("c", "", "controller host")
-	port := flag.Int("p", 0, "controller port")
+	// Ctrl-C or a TERM cancels ctx, which hands the current range back to the
+	// controller before exiting; a second one exits straight away
+	ctx, leave := context.WithCancel(context.Background())
+	defer leave()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Info("signal received, leaving", "signal", sig)
+			leave()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
 
-	flag.Parse()
-	if *host == "" || *port <= 0 || *port > 65535 || *threads <= 0 {
-		flag.Usage()
-		log.Fatal("Usage: worker -c HOST -p PORT -t THREADS")
+	cfg := Config{
+		Address:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		TLS:         tlsConfig,
+		WorkerId:    *workerId,
+		Threads:     threads,
+		AutoThreads: auto,
+		Bench:       *benchFor,
+		Logger:      log,
+	}
+	if err := run(ctx, cfg, opts); err != nil {
+		log.Error("worker failed", "err", err)
+		rec.Close()
+		os.Exit(1)
 	}
+}
 
-	// Connect to the controller
-	address := fmt.Sprintf("%s:%d", *host, *port)
-	conn, err := net.Dial("tcp", address)
+// parseThreads reads --threads: a count, or auto for calibrating up to the
+// number of CPUs
+func parseThreads(value string) (n int, auto bool, err error) {
+	if value == "auto" {
+		return runtime.NumCPU(), true, nil
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	n, err = strconv.Atoi(value)
+	if err != nil || n <= 0 || n > protocol.MaxThreads {
+		return 0, false, fmt.Errorf("%q is not auto or a count from 1 to %d", value, protocol.MaxThreads)
+	}
+	return n, false, nil
+}
+
+func defaultWorkerId() string {
+	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatal("connect error:", err)
+		hostname = "worker"
 	}
-	defer conn.Close()
-	log.Println("connected to controller")
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Serve runs a worker over an established connection, with fresh counters,
+// until the controller shuts it down or the connection drops, and reports
+// which it was. Cancelling ctx makes the worker leave. The caller closes
+// conn.
+func Serve(ctx context.Context, conn net.Conn, hello protocol.WorkerHello, threads int, log *slog.Logger) bool {
+	return runSession(ctx, conn, &hello, threads, nil, newTelemetry(threads), newProgressTracker(), nil, Events{}, log).shutdown
+}
+
+// sessionEnd is how a session with the controller ended
+type sessionEnd struct {
+	// shutdown is set once the controller shut the worker down
+	shutdown bool
+	// rejected is set when the controller answered with an error, such as
+	// having no work to hand out
+	rejected bool
+	// jobs is how many jobs the controller sent over the connection
+	jobs int
+}
+
+// runSession drives a single connection to the controller, running job after
+// job and idling in between, until the controller shuts the worker down or
+// the connection drops, in which case hello carries the point to resume from
+// on the next connection. Once ctx is cancelled it tells the controller it is
+// leaving and waits for it to let the worker go.
+//
+// Everything the session starts runs under a context of its own that ends
+// with the connection, and each job under one that the controller can
+// cancel or replace, so no goroutine outlives the session.
+func runSession(ctx context.Context, conn net.Conn, hello *protocol.WorkerHello, threads int, tuner *threadTuner, stats *telemetry, progress *progressTracker, rec *record.Recorder, events Events, log *slog.Logger) sessionEnd {
+	// Leaving is a conversation with the controller, so the connection
+	// outlives ctx until the controller lets go
+	connCtx, hangUp := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer hangUp(nil)
+
+	// A controller that stopped reading cannot hold the session open, the
+	// writer gets leaveTimeout to flush what is left
+	context.AfterFunc(connCtx, func() {
+		conn.SetWriteDeadline(time.Now().Add(leaveTimeout))
+	})
 
-	// Add Go routines to read and write to sockets (Handling Heartbeat request)
 	var wg sync.WaitGroup
+	defer wg.Wait()
 	writeCh := make(chan protocol.Message, 4)
-	jobCh := make(chan *protocol.CrackingJob, 1)
+	jobCh := make(chan queuedJob, 1)
+	var active activeJob
 
-	var delta_tested int64
-	var total_tested int64
+	beat := func() *protocol.HeartbeatResponse {
+		hb := heartbeat(stats, progress)
+		if events.Progress != nil {
+			events.Progress(*hb)
+		}
+		return hb
+	}
 
-	encoder := json.NewEncoder(conn)
-	decoder := json.NewDecoder(conn)
-	wg.Add(2)
+	peer := conn.RemoteAddr().String()
+	encoder := rec.Encoder(json.NewEncoder(conn), peer)
+	decoder := rec.Decoder(json.NewDecoder(conn), peer)
+	wg.Add(3)
 
 	go func() {
 		defer wg.Done()
-		writeRequests(encoder, writeCh, log)
+		writeRequests(connCtx, encoder, writeCh, log)
 	}()
 
 	go func() {
 		defer wg.Done()
-		readRequests(decoder, writeCh, jobCh, &delta_tested, &total_tested, log)
+		hangUp(readRequests(connCtx, decoder, writeCh, jobCh, beat, &active, log))
 	}()
 
-	writeCh <- protocol.Message{Command: protocol.MsgReady}
-	log.Printf("-> sent %s", protocol.MsgReady)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-connCtx.Done():
+			return
+		}
 
-	job := <-jobCh
-	jobReceiveEnd := time.Now()
+		log.Info("-> leaving", "command", protocol.MsgLeave)
+		if !send(connCtx, writeCh, protocol.Message{Command: protocol.MsgLeave, Heartbeat: beat()}) {
+			return
+		}
+		select {
+		case <-connCtx.Done():
+		case <-time.After(leaveTimeout):
+			log.Warn("controller did not let us go, hanging up")
+			conn.Close()
+		}
+	}()
+
+	var jobs int
+	end := func() sessionEnd {
+		cause := context.Cause(connCtx)
+		return sessionEnd{
+			shutdown: errors.Is(cause, errShutdown),
+			rejected: errors.Is(cause, errRejected),
+			jobs:     jobs,
+		}
+	}
 
-	log.Println("job received:")
-	log.Printf("\tid: %d", job.Id)
-	log.Printf("\tinterval: %d", job.Interval)
-	log.Printf("\tusername: %s", job.Username)
-	log.Printf("\tsettings: %s", job.Setting)
-	log.Printf("\tfull hash: %s", job.FullHash)
+	send(connCtx, writeCh, protocol.Message{Command: protocol.MsgReady, Hello: hello})
+	log.Debug("-> sent", "command", protocol.MsgReady)
+
+	for {
+		var queued queuedJob
+		select {
+		case queued = <-jobCh:
+		case <-connCtx.Done():
+			return end()
+		}
+		job, jobCtx := queued.job, queued.ctx
+		jobReceiveEnd := time.Now()
+		jobs++
+
+		log := log.With("job_id", job.Id)
+		log.Info("job received",
+			"interval", job.Interval,
+			"username", job.Username,
+			"settings", job.Setting,
+			"full_hash", job.FullHash,
+			"start", job.Start,
+			"end", job.End,
+		)
+
+		// Counters only carry over while the controller keeps us on one job
+		if progress.reset(job.Id, job.Start) {
+			stats.reset()
+		}
 
-	// Crack passwords
-	done := make(chan struct{})
+		// Crack passwords
+		// With --threads auto, threads is the most the worker may use
+		n := threads
+		if tuner != nil {
+			n = tuner.threadsFor(jobCtx, job, log)
+		}
+		stats.threads.Store(int64(n))
+
+		crackStart := time.Now()
+		res := crack(jobCtx, job, n, stats, progress)
+		totalCrackTime := time.Since(crackStart)
+		active.finish(queued.run)
+
+		if res.Replaced {
+			log.Info("job replaced by the next one")
+			continue
+		}
+		if res.Stopped {
+			if end := end(); end.shutdown {
+				log.Info("shutdown received before the job finished")
+				return end
+			}
+			hello.Resume = &protocol.ResumePoint{
+				JobId:         job.Id,
+				LastCompleted: progress.lastCompleted(),
+			}
+			return end()
+		}
+		if events.Result != nil {
+			events.Result(Result{
+				Job:       *job,
+				Password:  res.Found,
+				Found:     res.Found != "",
+				Cancelled: res.Cancelled,
+				Err:       res.Err,
+				Elapsed:   totalCrackTime,
+			})
+		}
+		if res.Err != nil {
+			log.Error("crack failed", "err", res.Err)
+			if !send(connCtx, writeCh, protocol.Message{Command: protocol.MsgError, Error: res.Err.Error()}) {
+				return end()
+			}
+			hello.Resume = nil
+			log.Info("idle, waiting for the next job")
+			continue
+		}
+
+		switch {
+		case res.Found != "":
+			log.Info("password found", "password", res.Found)
+		case res.Cancelled:
+			log.Info("job cancelled", "last_completed", progress.lastCompleted())
+		default:
+			log.Info("range exhausted without a match")
+		}
+		resultsSentStart := time.Now()
+		result := protocol.CrackResult{
+			JobId:         job.Id,
+			Password:      res.Found,
+			Cancelled:     res.Cancelled,
+			LastCompleted: progress.lastCompleted(),
+			Metrics: protocol.WorkerMetrics{
+				TotalCrackingTimeNanos: totalCrackTime.Nanoseconds(),
+				WorkerReceiveJobNanos:  jobReceiveEnd,
+				WorkerSentResultsNanos: resultsSentStart,
+			},
+		}
+
+		log.Debug("-> sending result", "command", protocol.MsgResult, "password", result.Password, "crack_time", totalCrackTime)
+		if !send(connCtx, writeCh, protocol.Message{Command: protocol.MsgResult, Result: &result}) {
+			hello.Resume = &protocol.ResumePoint{
+				JobId:         job.Id,
+				LastCompleted: progress.lastCompleted(),
+			}
+			return end()
+		}
+
+		hello.Resume = nil
+		log.Info("idle, waiting for the next job")
+	}
+}
+
+// Candidates a thread claims at a time: enough that claiming costs next to
+// nothing beside a fast hash, few enough that the threads finish a range
+// close together and a resume repeats little
+const batchSize = 64
+
+// batches hands the indices [next, end) out batchSize at a time
+type batches struct {
+	next atomic.Int64
+	end  int64
+}
+
+func newBatches(start int64, end int64) *batches {
+	b := &batches{end: end}
+	b.next.Store(start)
+	return b
+}
+
+// claim takes the next batch, [lo, hi), unless there is none left. It never
+// steps past end, which may lie close to the largest int64.
+func (b *batches) claim() (lo int64, hi int64, ok bool) {
+	for {
+		lo = b.next.Load()
+		if lo >= b.end {
+			return 0, 0, false
+		}
+		hi = lo + min(batchSize, b.end-lo)
+		if b.next.CompareAndSwap(lo, hi) {
+			return lo, hi, true
+		}
+	}
+}
+
+// crack tests job's range on the given number of threads until the password
+// is found, the range is exhausted, or ctx ends, and returns once every
+// thread has stopped. Each thread claims the next batch of indices and builds
+// their candidates in place in a buffer of its own, so no candidate crosses a
+// channel or allocates.
+func crack(ctx context.Context, job *protocol.CrackingJob, threads int, stats *telemetry, progress *progressTracker) ResultMsg {
+	charset := job.Charset
+	if charset == "" {
+		charset = keyspace.DefaultCharset
+	}
+	end := job.End
+	switch {
+	case job.Words != nil:
+		end = job.Start + int64(len(job.Words))
+	case end == 0:
+		end = keyspace.Offset(len(charset), keyspace.MaxLength(len(charset))+1)
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var stopped atomic.Bool
 	resultCh := make(chan ResultMsg, 1)
-	jobs := make(chan string, *threads)
 
-	var totalCrackTime time.Duration
-	crackStart := time.Now()
+	// The threads check stopped between candidates, which is cheaper than
+	// asking ctx every time
+	finish := func(res ResultMsg) {
+		once.Do(func() {
+			stopped.Store(true)
+			resultCh <- res
+		})
+	}
+	stopWatching := context.AfterFunc(ctx, func() {
+		switch cause := context.Cause(ctx); {
+		case errors.Is(cause, errCancelled):
+			finish(ResultMsg{Cancelled: true})
+		case errors.Is(cause, errReplaced):
+			finish(ResultMsg{Replaced: true})
+		default:
+			finish(ResultMsg{Stopped: true})
+		}
+	})
+
+	work := newBatches(job.Start, end)
 
-	for i := 0; i < *threads; i++ {
+	for i := 0; i < threads; i++ {
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
+			stats.busy.Add(1)
+			defer stats.busy.Add(-1)
+
+			checker := crypt.NewChecker(job.FullHash)
+			defer checker.Close()
+			var candidate []byte
 
 			for {
-				select {
-				case <-done:
+				lo, hi, ok := work.claim()
+				if !ok {
 					return
-				case test, ok := <-jobs:
-					if !ok {
+				}
+
+				var indices []int
+				if job.Words == nil {
+					indices = keyspace.Indices(len(charset), lo)
+				}
+				for index := lo; index < hi; index++ {
+					if stopped.Load() {
 						return
 					}
 
-					found, err := crackPassword(job, test)
-					atomic.AddInt64(&delta_tested, 1)
-					atomic.AddInt64(&total_tested, 1)
+					candidate = candidate[:0]
+					if job.Words != nil {
+						candidate = append(candidate, job.Words[index-job.Start]...)
+					} else {
+						for _, idx := range indices {
+							candidate = append(candidate, charset[idx])
+						}
+						indices = nextPassword(indices, len(charset))
+					}
+
+					found, err := checker.Check(candidate)
+					stats.tested[id].Add(1)
 
 					if err != nil {
-						resultCh <- ResultMsg{Err: err}
+						finish(ResultMsg{Err: err})
 						return
 					}
-
 					if found {
-						resultCh <- ResultMsg{Found: test}
-						close(done)
+						finish(ResultMsg{Found: string(candidate)})
 						return
 					}
 				}
+				progress.completeRange(lo, hi)
 			}
 		}(i)
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		indices := []int{0}
-
-		for {
-			select {
-			case <-done:
-				close(jobs)
-				return
-			default:
-				buf := make([]rune, len(indices))
-				for i, idx := range indices {
-					buf[i] = charset[idx]
-				}
-				jobs <- string(buf)
-				indices = nextPassword(indices)
-			}
-		}
-	}()
-
-	res := <-resultCh
-	totalCrackTime = time.Since(crackStart)
-	if res.Err != nil {
-		log.Fatal("crack failed:", res.Err)
-	}
-
-	log.Printf("password found: %s", res.Found)
-	resultsSentStart := time.Now()
-	result := protocol.CrackResult{
-		Password: res.Found,
-		Metrics: protocol.WorkerMetrics{
-			TotalCrackingTimeNanos: totalCrackTime.Nanoseconds(),
-			WorkerReceiveJobNanos:  jobReceiveEnd,
-			WorkerSentResultsNanos: resultsSentStart,
-		},
-	}
-
-	log.Printf("result ready: password=%q crackTime=%v", result.Password, time.Duration(result.Metrics.TotalCrackingTimeNanos))
-	log.Println("sending result")
-	writeCh <- protocol.Message{
-		Command: protocol.MsgResult,
-		Result:  &result,
-	}
-	close(writeCh)
-
 	wg.Wait()
-	os.Exit(0)
-}
-
-func crackPassword(job *protocol.CrackingJob, candidate string) (bool, error) {
-	data := C.struct_crypt_data{}
-	cPass := C.CString(candidate)
-	cHash := C.CString(job.FullHash)
-	defer C.free(unsafe.Pointer(cPass))
-	defer C.free(unsafe.Pointer(cHash))
+	stopWatching()
 
-	res := C.crypt_r(cPass, cHash, &data)
-	if res == nil {
-		return false, fmt.Errorf("crypt_r failed")
-	}
-	return C.GoString(res) == job.FullHash, nil
+	// Whole range tested without a match, unless something else finished
+	// first
+	finish(ResultMsg{})
+	return <-resultCh
 }
 
-func nextPassword(p []int) []int {
+func nextPassword(p []int, size int) []int {
 	pos := len(p) - 1
 
 	for pos >= 0 {
 		p[pos]++
-		if p[pos] < len(charset) {
+		if p[pos] < size {
 			return p
 		}
 		p[pos] = 0
@@ -1,3 +0,0 @@
-module controller
-
-go 1.22.2